@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -21,6 +22,21 @@ type JackAnalyzer struct {
 }
 
 func NewJackAnalyzer(srcFilePath string) (JackAnalyzer, error) {
+	// 既存のxmlの削除防止のために_をつける
+	return newJackAnalyzer(srcFilePath, "_p.xml", func(w io.Writer) JackWriter {
+		return NewXMLWriter(w)
+	})
+}
+
+// NewJackCompiler returns a JackAnalyzer writing the VM code of the class in
+// srcFilePath to a .vm file of the same name.
+func NewJackCompiler(srcFilePath string, native bool) (JackAnalyzer, error) {
+	return newJackAnalyzer(srcFilePath, ".vm", func(w io.Writer) JackWriter {
+		return NewVMWriter(w, native)
+	})
+}
+
+func newJackAnalyzer(srcFilePath, dstExt string, newWriter func(w io.Writer) JackWriter) (JackAnalyzer, error) {
 	if exists := ExistsFilePath(srcFilePath); !exists {
 		return JackAnalyzer{}, fmt.Errorf("expect file path, but %q is directory: ", srcFilePath)
 	}
@@ -31,8 +47,7 @@ func NewJackAnalyzer(srcFilePath string) (JackAnalyzer, error) {
 	distFileName := strings.TrimSuffix(filepath.Base(srcFilePath), jackExt)
 	dstDir := filepath.Dir(srcFilePath)
 	dstPath := filepath.Join(dstDir, distFileName)
-	// 既存のxmlの削除防止のために_をつける
-	dstFile, err := OpenFileWithReset(dstPath + "_p.xml")
+	dstFile, err := OpenFileWithReset(dstPath + dstExt)
 	if err != nil {
 		return JackAnalyzer{}, fmt.Errorf("failed to open or create file: %w", err)
 	}
@@ -40,14 +55,14 @@ func NewJackAnalyzer(srcFilePath string) (JackAnalyzer, error) {
 		src:       srcFile,
 		dst:       dstFile,
 		tokenizer: NewJackTokenizer(srcFile),
-		w:         NewXMLWriter(dstFile),
+		w:         newWriter(dstFile),
 	}, nil
 }
 
 func (ja JackAnalyzer) Analyze() error {
 	tokens, err := ja.tokenizer.Tokenize()
 	if err != nil {
-		return nil
+		return err
 	}
 	// if err := ja.w.WriteTokens(tokens); err != nil {
 	// 	return err
@@ -847,25 +847,25 @@ func (c *CompilationEngine) compileExpression() (*Token, error) {
 	}
 	expr.Children = append(expr.Children, term)
 
-	// if exists ["op"], parse "op" section
-	t, ok := c.next()
-	if !ok {
-		return nil, fmt.Errorf(ErrNoTokenExists, "op")
-	}
-	if !isOP(t) {
-		c.rewind()
-		return expr, nil
-	}
-	expr.Children = append(expr.Children, t)
+	// while exists ["op"], parse "op" term section
+	for {
+		t, ok := c.next()
+		if !ok {
+			return nil, fmt.Errorf(ErrNoTokenExists, "op")
+		}
+		if !isOP(t) {
+			c.rewind()
+			return expr, nil
+		}
+		expr.Children = append(expr.Children, t)
 
-	// compile term
-	term, err = c.compileTerm()
-	if err != nil {
-		return nil, err
+		// compile term
+		term, err = c.compileTerm()
+		if err != nil {
+			return nil, err
+		}
+		expr.Children = append(expr.Children, term)
 	}
-	expr.Children = append(expr.Children, term)
-
-	return expr, nil
 }
 
 func (c *CompilationEngine) compileTerm() (*Token, error) {
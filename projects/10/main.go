@@ -11,8 +11,17 @@ import (
 
 func main() {
 	src := flag.String("src", "", "source file/dir path")
+	vm := flag.Bool("vm", false, "compile each class to X.vm instead of writing its parse tree to X_p.xml")
+	native := flag.Bool("native", false, "with -vm, compile * and / to the mul and div commands of the extended VM translator of projects/7 instead of calls to Math.multiply and Math.divide")
 	flag.Parse()
 
+	newAnalyzer := func(path string) (JackAnalyzer, error) {
+		if *vm {
+			return NewJackCompiler(path, *native)
+		}
+		return NewJackAnalyzer(path)
+	}
+
 	if src == nil || *src == "" {
 		log.Fatal("not set source path")
 	}
@@ -20,7 +29,7 @@ func main() {
 	if strings.HasSuffix(filepath.Base(*src), jackExt) {
 		// process single .jack file
 		fmt.Printf("Processing file: %s\n", *src)
-		analyzer, err := NewJackAnalyzer(*src)
+		analyzer, err := newAnalyzer(*src)
 		defer func() {
 			if err := analyzer.Close(); err != nil {
 				fmt.Printf("Error while closeing files: %v\n", err)
@@ -44,7 +53,7 @@ func main() {
 			}
 			if strings.HasSuffix(d.Name(), jackExt) {
 				fmt.Printf("Processing file: %s\n", path)
-				analyzer, err := NewJackAnalyzer(path)
+				analyzer, err := newAnalyzer(path)
 				if err != nil {
 					log.Fatalf("%v\n", err)
 				}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"strings"
@@ -100,206 +99,79 @@ func NewJackTokenizer(src io.Reader) JackTokenizer {
 	return JackTokenizer{src: src}
 }
 
-const (
-	space = " "
-	lf    = "\n"
-	cr    = "\r"
-	crlf  = "\r\n"
-	tab   = "\t"
-)
-
 func (jt JackTokenizer) Tokenize() ([]*Token, error) {
-
-	var maybeCommentStartChars, maybeCommentEndChars string
-	isLineCommenting, isMultiLineCommenting, isAPICommenting := false, false, false
+	src, err := io.ReadAll(jt.src)
+	if err != nil {
+		return nil, err
+	}
+	rs := []rune(string(src))
 
 	tokens := []*Token{}
-	builder := strings.Builder{}
-	b := bufio.NewReader(jt.src)
-
-redo:
-	token := &Token{}
-	for {
-		r, _, err := b.ReadRune()
-		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
-			return nil, err
-		}
-		char := string(r)
-
-		if maybeCommentStartChars == "/" && !(char == "/" || char == "*") {
-			token = &Token{}
-			token.Type = symbol
-			token.Value = "/"
-			tokens = append(tokens, token)
-			maybeCommentStartChars = ""
-			token = &Token{}
-		}
-
-		// タブ、スペースはtokenize中以外無視
-		if char == space || char == tab {
-			if token.Type == stringConstant {
-				if _, err = builder.WriteRune(r); err != nil {
-					return tokens, err
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			// タブ、スペース、改行はトークンの区切り
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '/':
+			// 行コメントは改行まで読み飛ばす
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			// /* */ と /** */ のコメントは */ まで読み飛ばす
+			for i += 2; i+1 < len(rs) && !(rs[i] == '*' && rs[i+1] == '/'); i++ {
+			}
+			if i+1 >= len(rs) {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			i++
+		case r == '"':
+			// "が出たら次に"が出るまでstringConstant
+			builder := strings.Builder{}
+			for i++; ; i++ {
+				if i >= len(rs) || rs[i] == '\n' || rs[i] == '\r' {
+					return nil, fmt.Errorf("%v has appeared in token type %q", builder.String(), stringConstant)
 				}
-				continue
-			} else if token.Type != "" {
-				token.Value = builder.String()
-				tokens = append(tokens, token)
-				builder.Reset()
-				goto redo
-			}
-			continue
-		}
-
-		// 改行はトークンの区切りか行コメント解除
-		if char == lf || char == cr || char == crlf {
-			if token.Type == stringConstant {
-				return nil, fmt.Errorf("%v%q has appeared in token type %q", builder.String(), char, token.Type)
-			} else if token.Type != "" {
-				token.Value = builder.String()
-				tokens = append(tokens, token)
-				builder.Reset()
-				goto redo
-			}
-			if isLineCommenting {
-				maybeCommentStartChars = ""
-				isLineCommenting = false
-			}
-			continue
-		}
-
-		// /の場合は次の文字がコメントを示す文字か確認
-		if char == "/" {
-			if maybeCommentStartChars == "/" {
-				isLineCommenting = true
-				maybeCommentStartChars = ""
-			} else if !(isLineCommenting || isMultiLineCommenting || isAPICommenting) {
-				maybeCommentStartChars = "/"
-			}
-			if isMultiLineCommenting || isAPICommenting {
-				if maybeCommentEndChars == "*" {
-					maybeCommentStartChars = ""
-					maybeCommentEndChars = ""
-					isMultiLineCommenting = false
-					isAPICommenting = false
+				if rs[i] == '"' {
+					break
 				}
+				builder.WriteRune(rs[i])
 			}
-			continue
-		}
-
-		if char == "*" {
-			if isMultiLineCommenting || isAPICommenting {
-				maybeCommentEndChars = "*"
-				continue
-			}
-			if maybeCommentStartChars == "/" {
-				maybeCommentStartChars = "/*"
-				isMultiLineCommenting = true
-				continue
-			} else if maybeCommentStartChars == "/*" {
-				isMultiLineCommenting = false
-				isAPICommenting = true
-				continue
-			}
-			token.Type = symbol
-			token.Value = "*"
-			tokens = append(tokens, token)
-			goto redo
-		}
-
-		if isLineCommenting {
-			continue
-		}
-
-		if isMultiLineCommenting || isAPICommenting {
-			maybeCommentEndChars = ""
-			continue
-		}
-
-		// "が出たら次に"が出るまでstringConstant開始
-		if char == "\"" {
-			if token.Type == stringConstant {
-				token.Value = builder.String()
-				tokens = append(tokens, token)
-				builder.Reset()
-				goto redo
-			} else if token.Type == "" {
-				token.Type = stringConstant
-			} else {
-				return nil, fmt.Errorf("%v%q has appeared in token type %q", builder.String(), char, token.Type)
-			}
-			continue
-		}
-
-		// 数字が出たらintegerConstant
-		if unicode.IsNumber(r) {
-			if token.Type == "" {
-				token.Type = integerConstant
-			}
-			if _, err = builder.WriteRune(r); err != nil {
-				return tokens, err
+			tokens = append(tokens, &Token{Type: stringConstant, Value: builder.String()})
+		case unicode.IsNumber(r):
+			start := i
+			for i+1 < len(rs) && unicode.IsNumber(rs[i+1]) {
+				i++
 			}
-			continue
-		}
-
-		// アルファベットで始まったらidentifierかkeyword
-		if unicode.IsLetter(r) {
-			if token.Type == "" {
-				token.Type = identifier
-			} else if token.Type == integerConstant {
-				return nil, fmt.Errorf("%v%q has appeared in token type %q", builder.String(), char, token.Type)
+			if i+1 < len(rs) && (unicode.IsLetter(rs[i+1]) || rs[i+1] == '_') {
+				return nil, fmt.Errorf("%v%q has appeared in token type %q", string(rs[start:i+1]), rs[i+1], integerConstant)
 			}
-			if _, err = builder.WriteRune(r); err != nil {
-				return tokens, err
+			tokens = append(tokens, &Token{Type: integerConstant, Value: string(rs[start : i+1])})
+		case unicode.IsLetter(r) || r == '_':
+			// アルファベットで始まったらidentifierかkeyword
+			start := i
+			for i+1 < len(rs) && (unicode.IsLetter(rs[i+1]) || unicode.IsNumber(rs[i+1]) || rs[i+1] == '_') {
+				i++
 			}
-			isKeyword := false
+			token := &Token{Type: identifier, Value: string(rs[start : i+1])}
 			for _, k := range keywords {
-				if k == builder.String() {
+				if k == token.Value {
 					token.Type = keyword
-					token.Value = builder.String()
-					tokens = append(tokens, token)
-					builder.Reset()
-					isKeyword = true
-					goto redo
 				}
 			}
-			if isKeyword {
-				goto redo
-			}
-			continue
-		}
-
-		// symbolをハンドリング
-		isSymbol := false
-		for _, sym := range symbols {
-			if sym == char {
-				isSymbol = true
-			}
-		}
-		if isSymbol {
-			if token.Type != "" {
-				token.Value = builder.String()
-				tokens = append(tokens, token)
-				builder.Reset()
-			}
-			token = &Token{}
-			token.Type = symbol
-			token.Value = char
 			tokens = append(tokens, token)
-			goto redo
-		}
-
-		if token.Type == stringConstant {
-			if _, err = builder.WriteRune(r); err != nil {
-				return tokens, err
+		default:
+			isSymbol := false
+			for _, sym := range symbols {
+				if sym == string(r) {
+					isSymbol = true
+				}
+			}
+			if !isSymbol {
+				return nil, fmt.Errorf("invalid char %q has appeared", string(r))
 			}
-			continue
+			tokens = append(tokens, &Token{Type: symbol, Value: string(r)})
 		}
-
-		return nil, fmt.Errorf("invalid char %q has appeared in token type %q", char, token.Type)
 	}
 
 	return tokens, nil
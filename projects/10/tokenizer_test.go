@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -17,3 +18,33 @@ func TestTokenize(t *testing.T) {
 	}
 	fmt.Println(tokens)
 }
+
+func TestTokenizeValues(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"do done;", []string{"keyword:do", "identifier:done", "symbol:;"}},
+		{"a*b/12", []string{"identifier:a", "symbol:*", "identifier:b", "symbol:/", "integerConstant:12"}},
+		{`"a; b // c"`, []string{"stringConstant:a; b // c"}},
+		{"x /* a * b */ y // z\n/** doc */ z", []string{"identifier:x", "identifier:y", "identifier:z"}},
+	}
+	for _, tt := range tests {
+		tokens, err := NewJackTokenizer(strings.NewReader(tt.src)).Tokenize()
+		if err != nil {
+			t.Fatalf("%q: %v", tt.src, err)
+		}
+		got := make([]string, len(tokens))
+		for i, token := range tokens {
+			got[i] = string(token.Type) + ":" + token.Value
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%q: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+	for _, src := range []string{`"unterminated`, "/* unterminated", "12ab", "#"} {
+		if _, err := NewJackTokenizer(strings.NewReader(src)).Tokenize(); err == nil {
+			t.Errorf("no error for %q", src)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// VMWriter writes the VM code of a parsed class. With native set, "*" and "/"
+// compile to the mul and div commands of the extended VM translator
+// (projects/7) instead of calls to Math.multiply and Math.divide.
+type VMWriter struct {
+	w      io.Writer
+	native bool
+}
+
+func NewVMWriter(w io.Writer, native bool) VMWriter {
+	return VMWriter{w: w, native: native}
+}
+
+func (v VMWriter) WriteTokens(tokens []*Token) error {
+	return fmt.Errorf("VM code is written from the parse tree, not from tokens")
+}
+
+func (v VMWriter) WriteParsedTokens(token *Token) error {
+	g := &codeGenerator{native: v.native}
+	if err := g.compileClass(token); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(v.w, g.out.String()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// variable is an entry of a symbol table.
+type variable struct {
+	// segment is one of static, this, argument and local.
+	segment string
+	typ     string
+	index   int
+}
+
+type codeGenerator struct {
+	native bool
+
+	className, function string
+	class, subroutine   map[string]variable
+	// labels numbers the labels of if and while in the current subroutine.
+	labels int
+	out    strings.Builder
+}
+
+func (g *codeGenerator) writef(format string, args ...any) {
+	fmt.Fprintf(&g.out, format+"\n", args...)
+}
+
+func (g *codeGenerator) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s: %s", g.function, fmt.Sprintf(format, args...))
+}
+
+// define adds name to scope with the next index of segment.
+func define(scope map[string]variable, segment, typ, name string) {
+	scope[name] = variable{segment: segment, typ: typ, index: count(scope, segment)}
+}
+
+func count(scope map[string]variable, segment string) int {
+	n := 0
+	for _, v := range scope {
+		if v.segment == segment {
+			n++
+		}
+	}
+	return n
+}
+
+func (g *codeGenerator) lookup(name string) (variable, bool) {
+	if v, ok := g.subroutine[name]; ok {
+		return v, true
+	}
+	v, ok := g.class[name]
+	return v, ok
+}
+
+// ------------- program structures -------------
+
+func (g *codeGenerator) compileClass(class *Token) error {
+	g.className = class.Children[1].Value
+	g.class = map[string]variable{}
+	for _, c := range class.Children {
+		switch c.Type {
+		case classVarDec:
+			segment := "static"
+			if c.Children[0].Value == "field" {
+				segment = "this"
+			}
+			for _, name := range c.Children[2:] {
+				if name.Type == identifier {
+					define(g.class, segment, c.Children[1].Value, name.Value)
+				}
+			}
+		case subroutineDec:
+			if err := g.compileSubroutine(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *codeGenerator) compileSubroutine(dec *Token) error {
+	kind := dec.Children[0].Value
+	g.function = g.className + "." + dec.Children[2].Value
+	g.subroutine = map[string]variable{}
+	g.labels = 0
+	if kind == "method" {
+		define(g.subroutine, "argument", g.className, "this")
+	}
+	// parameterList is type name ("," type name)*
+	var params []*Token
+	for _, p := range dec.Children[4].Children {
+		if p.Value != "," {
+			params = append(params, p)
+		}
+	}
+	for i := 0; i+1 < len(params); i += 2 {
+		define(g.subroutine, "argument", params[i].Value, params[i+1].Value)
+	}
+
+	body := dec.Children[6]
+	var stmts *Token
+	for _, c := range body.Children {
+		switch c.Type {
+		case varDec:
+			for _, name := range c.Children[2:] {
+				if name.Type == identifier {
+					define(g.subroutine, "local", c.Children[1].Value, name.Value)
+				}
+			}
+		case statements:
+			stmts = c
+		}
+	}
+
+	g.writef("function %s %d", g.function, count(g.subroutine, "local"))
+	switch kind {
+	case "constructor":
+		g.writef("push constant %d", count(g.class, "this"))
+		g.writef("call Memory.alloc 1")
+		g.writef("pop pointer 0")
+	case "method":
+		g.writef("push argument 0")
+		g.writef("pop pointer 0")
+	}
+	return g.compileStatements(stmts)
+}
+
+// ------------- statements -------------
+
+func (g *codeGenerator) compileStatements(stmts *Token) error {
+	for _, s := range stmts.Children {
+		var err error
+		switch s.Type {
+		case letStatement:
+			err = g.compileLet(s)
+		case ifStatement:
+			err = g.compileIf(s)
+		case whileStatement:
+			err = g.compileWhile(s)
+		case doStatement:
+			err = g.compileDo(s)
+		case returnStatement:
+			err = g.compileReturn(s)
+		default:
+			err = g.errorf("unexpected statement %q", s.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *codeGenerator) compileLet(s *Token) error {
+	// "let" varName ("[" expression "]")? "=" expression ";"
+	v, ok := g.lookup(s.Children[1].Value)
+	if !ok {
+		return g.errorf("undefined variable %q", s.Children[1].Value)
+	}
+	if s.Children[2].Value != "[" {
+		if err := g.compileExpression(s.Children[3]); err != nil {
+			return err
+		}
+		g.writef("pop %s %d", v.segment, v.index)
+		return nil
+	}
+	g.writef("push %s %d", v.segment, v.index)
+	if err := g.compileExpression(s.Children[3]); err != nil {
+		return err
+	}
+	g.writef("add")
+	if err := g.compileExpression(s.Children[6]); err != nil {
+		return err
+	}
+	// The value may itself use that, so the address is set after it.
+	g.writef("pop temp 0")
+	g.writef("pop pointer 1")
+	g.writef("push temp 0")
+	g.writef("pop that 0")
+	return nil
+}
+
+func (g *codeGenerator) compileIf(s *Token) error {
+	// "if" "(" expression ")" "{" statements "}" ("else" "{" statements "}")?
+	n := g.labels
+	g.labels++
+	if err := g.compileExpression(s.Children[2]); err != nil {
+		return err
+	}
+	g.writef("not")
+	g.writef("if-goto IF_FALSE%d", n)
+	if err := g.compileStatements(s.Children[5]); err != nil {
+		return err
+	}
+	if len(s.Children) == 7 {
+		g.writef("label IF_FALSE%d", n)
+		return nil
+	}
+	g.writef("goto IF_END%d", n)
+	g.writef("label IF_FALSE%d", n)
+	if err := g.compileStatements(s.Children[9]); err != nil {
+		return err
+	}
+	g.writef("label IF_END%d", n)
+	return nil
+}
+
+func (g *codeGenerator) compileWhile(s *Token) error {
+	// "while" "(" expression ")" "{" statements "}"
+	n := g.labels
+	g.labels++
+	g.writef("label WHILE_EXP%d", n)
+	if err := g.compileExpression(s.Children[2]); err != nil {
+		return err
+	}
+	g.writef("not")
+	g.writef("if-goto WHILE_END%d", n)
+	if err := g.compileStatements(s.Children[5]); err != nil {
+		return err
+	}
+	g.writef("goto WHILE_EXP%d", n)
+	g.writef("label WHILE_END%d", n)
+	return nil
+}
+
+func (g *codeGenerator) compileDo(s *Token) error {
+	// "do" subroutineCall ";"
+	if err := g.compileCall(s.Children[1 : len(s.Children)-1]); err != nil {
+		return err
+	}
+	g.writef("pop temp 0")
+	return nil
+}
+
+func (g *codeGenerator) compileReturn(s *Token) error {
+	// "return" expression? ";"
+	if len(s.Children) == 3 {
+		if err := g.compileExpression(s.Children[1]); err != nil {
+			return err
+		}
+	} else {
+		g.writef("push constant 0")
+	}
+	g.writef("return")
+	return nil
+}
+
+// ------------- expression -------------
+
+var opCommands = map[string]string{
+	"+": "add",
+	"-": "sub",
+	"&": "and",
+	"|": "or",
+	"<": "lt",
+	">": "gt",
+	"=": "eq",
+	"*": "call Math.multiply 2",
+	"/": "call Math.divide 2",
+}
+
+func (g *codeGenerator) compileExpression(expr *Token) error {
+	// term (op term)*, evaluated from left to right
+	if err := g.compileTerm(expr.Children[0]); err != nil {
+		return err
+	}
+	for i := 1; i+1 < len(expr.Children); i += 2 {
+		if err := g.compileTerm(expr.Children[i+1]); err != nil {
+			return err
+		}
+		op := expr.Children[i].Value
+		switch {
+		case g.native && op == "*":
+			g.writef("mul")
+		case g.native && op == "/":
+			g.writef("div")
+		default:
+			g.writef("%s", opCommands[op])
+		}
+	}
+	return nil
+}
+
+func (g *codeGenerator) compileTerm(term *Token) error {
+	if len(term.Children) == 0 {
+		return g.errorf("empty term")
+	}
+	t := term.Children[0]
+	switch t.Type {
+	case integerConstant:
+		n, err := strconv.Atoi(t.Value)
+		if err != nil || n > 32767 {
+			return g.errorf("invalid integer constant %q", t.Value)
+		}
+		g.writef("push constant %d", n)
+	case stringConstant:
+		s := []rune(t.Value)
+		g.writef("push constant %d", len(s))
+		g.writef("call String.new 1")
+		for _, r := range s {
+			g.writef("push constant %d", r)
+			g.writef("call String.appendChar 2")
+		}
+	case keyword:
+		switch t.Value {
+		case "true":
+			g.writef("push constant 0")
+			g.writef("not")
+		case "false", "null":
+			g.writef("push constant 0")
+		case "this":
+			g.writef("push pointer 0")
+		}
+	case symbol:
+		switch t.Value {
+		case "(":
+			return g.compileExpression(term.Children[1])
+		case "-", "~":
+			if err := g.compileTerm(term.Children[1]); err != nil {
+				return err
+			}
+			if t.Value == "-" {
+				g.writef("neg")
+			} else {
+				g.writef("not")
+			}
+		}
+	case identifier:
+		if len(term.Children) > 1 && (term.Children[1].Value == "(" || term.Children[1].Value == ".") {
+			return g.compileCall(term.Children)
+		}
+		v, ok := g.lookup(t.Value)
+		if !ok {
+			return g.errorf("undefined variable %q", t.Value)
+		}
+		g.writef("push %s %d", v.segment, v.index)
+		if len(term.Children) > 1 {
+			// varName "[" expression "]"
+			if err := g.compileExpression(term.Children[2]); err != nil {
+				return err
+			}
+			g.writef("add")
+			g.writef("pop pointer 1")
+			g.writef("push that 0")
+		}
+	}
+	return nil
+}
+
+// compileCall compiles the tokens of a subroutineCall:
+// subroutineName "(" expressionList ")" or
+// (className | varName) "." subroutineName "(" expressionList ")".
+func (g *codeGenerator) compileCall(call []*Token) error {
+	var name string
+	var args *Token
+	nArgs := 0
+	if call[1].Value == "(" {
+		// a method of this object
+		g.writef("push pointer 0")
+		name, args, nArgs = g.className+"."+call[0].Value, call[2], 1
+	} else if v, ok := g.lookup(call[0].Value); ok {
+		// a method of the object in varName
+		g.writef("push %s %d", v.segment, v.index)
+		name, args, nArgs = v.typ+"."+call[2].Value, call[4], 1
+	} else {
+		name, args = call[0].Value+"."+call[2].Value, call[4]
+	}
+	for _, expr := range args.Children {
+		if expr.Type != expression {
+			continue
+		}
+		if err := g.compileExpression(expr); err != nil {
+			return err
+		}
+		nArgs++
+	}
+	g.writef("call %s %d", name, nArgs)
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func compileVM(t *testing.T, src string, native bool) string {
+	t.Helper()
+	tokens, err := NewJackTokenizer(strings.NewReader(src)).Tokenize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := NewCompilationEngine(tokens).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	if err := NewVMWriter(&sb, native).WriteParsedTokens(parsed); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+const pointSrc = `class Point {
+    field int x, y;
+    static int count;
+
+    constructor Point new(int ax, int ay) {
+        let x = ax;
+        let y = ay;
+        let count = count + 1;
+        return this;
+    }
+
+    method int dist2(Point p) {
+        var int dx, dy;
+        let dx = x - p.getX();
+        let dy = y - p.getY();
+        return dx * dx + (dy * dy);
+    }
+
+    function void fill(Array a, int n) {
+        while (n > 0) {
+            let n = n - 1;
+            let a[n] = a[n + 1] / 2;
+        }
+        if (~(n = 0)) {
+            do Output.printString("n: ");
+        } else {
+            do Point.fill(a, -1);
+        }
+        return;
+    }
+}
+`
+
+func TestVMWriter(t *testing.T) {
+	want := `function Point.new 0
+push constant 2
+call Memory.alloc 1
+pop pointer 0
+push argument 0
+pop this 0
+push argument 1
+pop this 1
+push static 0
+push constant 1
+add
+pop static 0
+push pointer 0
+return
+function Point.dist2 2
+push argument 0
+pop pointer 0
+push this 0
+push argument 1
+call Point.getX 1
+sub
+pop local 0
+push this 1
+push argument 1
+call Point.getY 1
+sub
+pop local 1
+push local 0
+push local 0
+call Math.multiply 2
+push local 1
+push local 1
+call Math.multiply 2
+add
+return
+function Point.fill 0
+label WHILE_EXP0
+push argument 1
+push constant 0
+gt
+not
+if-goto WHILE_END0
+push argument 1
+push constant 1
+sub
+pop argument 1
+push argument 0
+push argument 1
+add
+push argument 0
+push argument 1
+push constant 1
+add
+add
+pop pointer 1
+push that 0
+push constant 2
+call Math.divide 2
+pop temp 0
+pop pointer 1
+push temp 0
+pop that 0
+goto WHILE_EXP0
+label WHILE_END0
+push argument 1
+push constant 0
+eq
+not
+not
+if-goto IF_FALSE1
+push constant 3
+call String.new 1
+push constant 110
+call String.appendChar 2
+push constant 58
+call String.appendChar 2
+push constant 32
+call String.appendChar 2
+call Output.printString 1
+pop temp 0
+goto IF_END1
+label IF_FALSE1
+push argument 0
+push constant 1
+neg
+call Point.fill 2
+pop temp 0
+label IF_END1
+push constant 0
+return
+`
+	if got := compileVM(t, pointSrc, false); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestVMWriterNative(t *testing.T) {
+	got := compileVM(t, pointSrc, true)
+	if strings.Contains(got, "Math.") {
+		t.Errorf("got calls to Math:\n%v", got)
+	}
+	if strings.Count(got, "\nmul\n") != 2 || strings.Count(got, "\ndiv\n") != 1 {
+		t.Errorf("got\n%v\nwant 2 mul and 1 div", got)
+	}
+}
+
+func TestVMWriterErrors(t *testing.T) {
+	src := `class Main {
+    function void main() {
+        let x = 1;
+        return;
+    }
+}
+`
+	tokens, err := NewJackTokenizer(strings.NewReader(src)).Tokenize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := NewCompilationEngine(tokens).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewVMWriter(&strings.Builder{}, false).WriteParsedTokens(parsed)
+	if err == nil || !strings.Contains(err.Error(), `Main.main: undefined variable "x"`) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCompilePrograms(t *testing.T) {
+	paths, err := filepath.Glob("../11/*/*.jack")
+	if err != nil || len(paths) == 0 {
+		t.Fatal(paths, err)
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := compileVM(t, string(src), false); !strings.HasPrefix(out, "function ") {
+			t.Errorf("%v: got\n%v", path, out)
+		}
+	}
+}
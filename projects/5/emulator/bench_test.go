@@ -0,0 +1,68 @@
+package emulator
+
+import (
+	"testing"
+)
+
+// countdown decrements R0 from 32767 to 0 and halts.
+var countdown = []uint16{
+	0b0111111111111111, // @32767
+	0b1110110000010000, // D=A
+	0b0000000000000000, // @R0
+	0b1110001100001000, // M=D
+	0b0000000000000000, // (LOOP) @R0
+	0b1111110010001000, // M=M-1
+	0b1111110000010000, // D=M
+	0b0000000000000100, // @LOOP
+	0b1110001100000001, // D;JGT
+	0b0000000000001001, // (END) @END
+	0b1110101010000111, // 0;JMP
+}
+
+func reportThroughput(b *testing.B, cycles uint64) {
+	b.ReportMetric(float64(cycles)/b.Elapsed().Seconds(), "instr/s")
+	b.ReportMetric(float64(cycles)/float64(b.N), "instr/op")
+}
+
+func BenchmarkCountdown(b *testing.B) {
+	m := New()
+	if err := m.Load(countdown); err != nil {
+		b.Fatal(err)
+	}
+	var cycles uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Reset()
+		m.Run(1 << 20)
+		cycles += m.Cycles
+	}
+	reportThroughput(b, cycles)
+}
+
+func BenchmarkRect(b *testing.B) {
+	m := loadFile(b, "../Rect.hack")
+	var cycles uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Reset()
+		m.RAM[0] = 256
+		m.Run(1 << 20)
+		cycles += m.Cycles
+	}
+	reportThroughput(b, cycles)
+}
+
+func BenchmarkStep(b *testing.B) {
+	m := New()
+	if err := m.Load(countdown); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if m.Halted {
+			m.Reset()
+		}
+		m.Step()
+	}
+	reportThroughput(b, uint64(b.N))
+}
@@ -0,0 +1,149 @@
+package emulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// comp is the ALU function of a C instruction, predecoded from the a-bit and c1..c6.
+type comp uint8
+
+const (
+	compZero comp = iota
+	compOne
+	compNegOne
+	compD
+	compA
+	compNotD
+	compNotA
+	compNegD
+	compNegA
+	compDPlus1
+	compAPlus1
+	compDMinus1
+	compAMinus1
+	compDPlusA
+	compDMinusA
+	compAMinusD
+	compDAndA
+	compDOrA
+	compM
+	compNotM
+	compNegM
+	compMPlus1
+	compMMinus1
+	compDPlusM
+	compDMinusM
+	compMMinusD
+	compDAndM
+	compDOrM
+)
+
+// a-bit + c1..c6 of the Hack specification.
+var compCodes = map[uint16]comp{
+	0b0101010: compZero,
+	0b0111111: compOne,
+	0b0111010: compNegOne,
+	0b0001100: compD,
+	0b0110000: compA,
+	0b0001101: compNotD,
+	0b0110001: compNotA,
+	0b0001111: compNegD,
+	0b0110011: compNegA,
+	0b0011111: compDPlus1,
+	0b0110111: compAPlus1,
+	0b0001110: compDMinus1,
+	0b0110010: compAMinus1,
+	0b0000010: compDPlusA,
+	0b0010011: compDMinusA,
+	0b0000111: compAMinusD,
+	0b0000000: compDAndA,
+	0b0010101: compDOrA,
+	0b1110000: compM,
+	0b1110001: compNotM,
+	0b1110011: compNegM,
+	0b1110111: compMPlus1,
+	0b1110010: compMMinus1,
+	0b1000010: compDPlusM,
+	0b1010011: compDMinusM,
+	0b1000111: compMMinusD,
+	0b1000000: compDAndM,
+	0b1010101: compDOrM,
+}
+
+const (
+	destM uint8 = 1 << iota
+	destD
+	destA
+)
+
+const (
+	jumpGT uint8 = 1 << iota
+	jumpEQ
+	jumpLT
+)
+
+// op is a predecoded instruction. The zero value is "@0", same as an all-zero word.
+type op struct {
+	c     bool
+	value int16 // A instruction constant
+	comp  comp
+	dest  uint8
+	jump  uint8
+	// halt marks "@n" at address n followed by "0;JMP", the conventional end loop.
+	halt bool
+}
+
+func decode(word uint16) (op, error) {
+	if word&0x8000 == 0 {
+		return op{value: int16(word)}, nil
+	}
+	c, ok := compCodes[(word>>6)&0x7f]
+	if !ok {
+		return op{}, fmt.Errorf("unknown comp bits %07b in instruction %016b", (word>>6)&0x7f, word)
+	}
+	return op{
+		c:    true,
+		comp: c,
+		dest: uint8(word>>3) & 0x7,
+		jump: uint8(word) & 0x7,
+	}, nil
+}
+
+// ReadHack reads a .hack file, one 16 character binary word per line.
+func ReadHack(r io.Reader) ([]uint16, error) {
+	var program []uint16
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(line) != 16 {
+			return nil, fmt.Errorf("line %v: expected 16 binary digits, but %q", lineNo, line)
+		}
+		var word uint16
+		for _, r := range line {
+			switch r {
+			case '0':
+				word <<= 1
+			case '1':
+				word = word<<1 | 1
+			default:
+				return nil, fmt.Errorf("line %v: invalid binary digit %q", lineNo, r)
+			}
+		}
+		program = append(program, word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(program) > romSize {
+		return nil, fmt.Errorf("program has %v instructions, but ROM holds %v", len(program), romSize)
+	}
+	return program, nil
+}
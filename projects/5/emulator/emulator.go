@@ -0,0 +1,200 @@
+package emulator
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	romSize = 32768
+	ramSize = 32768 // the Hack address space is 15 bits; only 0..KBD are backed by devices
+
+	Screen   = 0x4000
+	Keyboard = 0x6000
+)
+
+// Machine is the Hack computer. The ROM is predecoded once at load time so that
+// Run is a tight loop over an op table instead of decoding words every cycle.
+type Machine struct {
+	rom [romSize]op
+	RAM [ramSize]int16
+
+	A, D int16
+	PC   uint16
+
+	// Cycles counts executed instructions since the last Reset.
+	Cycles uint64
+	// Halted is set when the PC enters the conventional "(END) @END 0;JMP" loop.
+	Halted bool
+}
+
+func New() *Machine {
+	return &Machine{}
+}
+
+// Load predecodes program into the ROM and resets the CPU. RAM is kept as is.
+func (m *Machine) Load(program []uint16) error {
+	if len(program) > romSize {
+		return fmt.Errorf("program has %v instructions, but ROM holds %v", len(program), romSize)
+	}
+	m.rom = [romSize]op{}
+	for i, word := range program {
+		o, err := decode(word)
+		if err != nil {
+			return fmt.Errorf("ROM[%v]: %w", i, err)
+		}
+		m.rom[i] = o
+	}
+	for i := 0; i+1 < len(program); i++ {
+		a, next := &m.rom[i], m.rom[i+1]
+		if !a.c && int(a.value) == i && next.c && next.dest == 0 && next.jump == jumpGT|jumpEQ|jumpLT {
+			a.halt = true
+		}
+	}
+	m.Reset()
+	return nil
+}
+
+// LoadHack reads a .hack file and loads it into the ROM.
+func (m *Machine) LoadHack(r io.Reader) error {
+	program, err := ReadHack(r)
+	if err != nil {
+		return err
+	}
+	return m.Load(program)
+}
+
+// Reset sets the PC to 0 as the reset pin of the Computer chip does.
+func (m *Machine) Reset() {
+	m.PC = 0
+	m.Cycles = 0
+	m.Halted = false
+}
+
+// Step executes a single instruction.
+func (m *Machine) Step() {
+	m.Run(1)
+}
+
+// Run executes up to n instructions and returns how many were executed.
+// It stops early when the machine halts.
+func (m *Machine) Run(n uint64) uint64 {
+	rom := &m.rom
+	ram := &m.RAM
+	a, d, pc := m.A, m.D, m.PC
+
+	var i uint64
+	for ; i < n; i++ {
+		o := &rom[pc&(romSize-1)]
+		if !o.c {
+			if o.halt {
+				m.Halted = true
+				break
+			}
+			a = o.value
+			pc++
+			continue
+		}
+
+		addr := uint16(a) & (ramSize - 1)
+		var v int16
+		switch o.comp {
+		case compZero:
+			v = 0
+		case compOne:
+			v = 1
+		case compNegOne:
+			v = -1
+		case compD:
+			v = d
+		case compA:
+			v = a
+		case compNotD:
+			v = ^d
+		case compNotA:
+			v = ^a
+		case compNegD:
+			v = -d
+		case compNegA:
+			v = -a
+		case compDPlus1:
+			v = d + 1
+		case compAPlus1:
+			v = a + 1
+		case compDMinus1:
+			v = d - 1
+		case compAMinus1:
+			v = a - 1
+		case compDPlusA:
+			v = d + a
+		case compDMinusA:
+			v = d - a
+		case compAMinusD:
+			v = a - d
+		case compDAndA:
+			v = d & a
+		case compDOrA:
+			v = d | a
+		case compM:
+			v = ram[addr]
+		case compNotM:
+			v = ^ram[addr]
+		case compNegM:
+			v = -ram[addr]
+		case compMPlus1:
+			v = ram[addr] + 1
+		case compMMinus1:
+			v = ram[addr] - 1
+		case compDPlusM:
+			v = d + ram[addr]
+		case compDMinusM:
+			v = d - ram[addr]
+		case compMMinusD:
+			v = ram[addr] - d
+		case compDAndM:
+			v = d & ram[addr]
+		case compDOrM:
+			v = d | ram[addr]
+		}
+
+		// M and the jump target both use A as it was before this instruction.
+		target := uint16(a)
+		if o.dest&destM != 0 {
+			ram[addr] = v
+		}
+		if o.dest&destA != 0 {
+			a = v
+		}
+		if o.dest&destD != 0 {
+			d = v
+		}
+
+		var cond uint8
+		switch {
+		case v < 0:
+			cond = jumpLT
+		case v == 0:
+			cond = jumpEQ
+		default:
+			cond = jumpGT
+		}
+		if o.jump&cond != 0 {
+			pc = target
+		} else {
+			pc++
+		}
+	}
+
+	m.A, m.D, m.PC = a, d, pc
+	m.Cycles += i
+	return i
+}
+
+// RunUntilHalt runs until the machine halts or max instructions have been executed.
+func (m *Machine) RunUntilHalt(max uint64) error {
+	m.Run(max)
+	if !m.Halted {
+		return fmt.Errorf("machine did not halt within %v instructions (PC=%v)", max, m.PC)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package emulator
+
+import (
+	"os"
+	"testing"
+)
+
+func loadFile(t testing.TB, path string) *Machine {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m := New()
+	if err := m.LoadHack(f); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestAdd(t *testing.T) {
+	m := loadFile(t, "../Add.hack")
+	m.Run(6)
+	if m.RAM[0] != 5 {
+		t.Fatalf("RAM[0] = %v, want 5", m.RAM[0])
+	}
+}
+
+func TestMax(t *testing.T) {
+	tests := []struct{ x, y, want int16 }{
+		{3, 5, 5},
+		{23456, 12345, 23456},
+		{-1, -7, -1},
+	}
+	m := loadFile(t, "../Max.hack")
+	for _, tt := range tests {
+		m.Reset()
+		m.RAM[0], m.RAM[1] = tt.x, tt.y
+		if err := m.RunUntilHalt(1000); err != nil {
+			t.Fatal(err)
+		}
+		if m.RAM[2] != tt.want {
+			t.Errorf("max(%v, %v) = %v, want %v", tt.x, tt.y, m.RAM[2], tt.want)
+		}
+	}
+}
+
+func TestRect(t *testing.T) {
+	m := loadFile(t, "../Rect.hack")
+	m.RAM[0] = 4
+	if err := m.RunUntilHalt(10000); err != nil {
+		t.Fatal(err)
+	}
+	for row := 0; row < 5; row++ {
+		want := int16(-1)
+		if row == 4 {
+			want = 0
+		}
+		if got := m.RAM[Screen+row*32]; got != want {
+			t.Errorf("screen row %v = %v, want %v", row, got, want)
+		}
+	}
+}
+
+func TestJumpUsesPreviousA(t *testing.T) {
+	m := New()
+	err := m.Load([]uint16{
+		0b0000000000000100, // @4
+		0b1110110000101111, // AM=A;JMP stores 4 into RAM[4] and jumps to 4
+		0b0000000000000010, // @2 (skipped)
+		0b0000000000000010, // @2 (skipped)
+		0b1110111111010000, // D=1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Run(3)
+	if m.RAM[4] != 4 || m.D != 1 || m.PC != 5 {
+		t.Fatalf("RAM[4]=%v D=%v PC=%v, want 4 1 5", m.RAM[4], m.D, m.PC)
+	}
+}
+
+func TestLoadInvalidComp(t *testing.T) {
+	if err := New().Load([]uint16{0b1111111111000000}); err == nil {
+		t.Fatal("expected error for unknown comp bits")
+	}
+}
@@ -0,0 +1,3 @@
+module nand2tetris-5
+
+go 1.23.2
@@ -0,0 +1,446 @@
+package hdl
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// primitives can never be replaced by an .hdl file.
+var primitives = map[string]bool{
+	"Nand": true,
+	"DFF":  true,
+}
+
+// builtins are the chips of the standard library, implemented in Go like the
+// builtin chips of the HardwareSimulator. They are used when a part is not
+// found as an .hdl file.
+var builtins = map[string]*Chip{}
+
+func bit(name string) Pin {
+	return Pin{Name: name, Width: 1}
+}
+
+func bus(name string, width int) Pin {
+	return Pin{Name: name, Width: width}
+}
+
+func pins(ps ...Pin) []Pin {
+	return ps
+}
+
+// defineGate registers a combinational builtin chip. f reads the inputs from
+// p and writes the outputs after them.
+func defineGate(name string, in, out []Pin, f func(p []uint16)) {
+	defineBuiltin(name, in, out, nil, false, func(e *env) instance {
+		return &gate{p: make([]uint16, len(in)+len(out)), f: f}
+	})
+}
+
+func defineBuiltin(name string, in, out []Pin, clocked []string, stateful bool, newInst func(*env) instance) {
+	c := &Chip{
+		Name:       name,
+		Inputs:     in,
+		Outputs:    out,
+		Stateful:   stateful,
+		newBuiltin: newInst,
+	}
+	var comb uint64
+	for i, p := range in {
+		isClocked := false
+		for _, name := range clocked {
+			isClocked = isClocked || name == p.Name
+		}
+		if !isClocked {
+			comb |= 1 << i
+		}
+	}
+	c.comb = make([]uint64, len(out))
+	for o := range out {
+		c.comb[o] = comb
+	}
+	builtins[name] = c
+}
+
+type gate struct {
+	p []uint16
+	f func(p []uint16)
+}
+
+func (g *gate) pins() []uint16 { return g.p }
+func (g *gate) eval()          { g.f(g.p) }
+func (g *gate) tick()          {}
+func (g *gate) tock()          {}
+func (g *gate) invalidate()    {}
+
+// memory is implemented by builtin chips whose state can be read and written
+// by test scripts as Name[] or Name[index].
+type memory interface {
+	get(index int) (uint16, bool)
+	set(index int, v uint16) bool
+}
+
+// loader is implemented by builtin chips that can load a file, like "ROM32K load Max.hack".
+type loader interface {
+	load(path string) error
+}
+
+// register is DFF, Bit, Register and friends: in is stored on tick when load
+// is 1, and becomes visible on out on tock.
+type register struct {
+	p          []uint16
+	value      uint16
+	out        uint16
+	mask       uint16
+	alwaysLoad bool
+}
+
+func (r *register) pins() []uint16 { return r.p }
+func (r *register) eval()          { r.p[len(r.p)-1] = r.out }
+func (r *register) tick() {
+	if r.alwaysLoad || r.p[1]&1 != 0 {
+		r.value = r.p[0] & r.mask
+	}
+}
+func (r *register) tock()       { r.out = r.value }
+func (r *register) invalidate() {}
+
+func (r *register) get(index int) (uint16, bool) {
+	if index > 0 {
+		return 0, false
+	}
+	return r.value, true
+}
+
+func (r *register) set(index int, v uint16) bool {
+	if index > 0 {
+		return false
+	}
+	r.value, r.out = v&r.mask, v&r.mask
+	return true
+}
+
+type counter struct {
+	p     []uint16
+	value uint16
+	out   uint16
+}
+
+func (c *counter) pins() []uint16 { return c.p }
+func (c *counter) eval()          { c.p[4] = c.out }
+
+// tick implements: if reset 0, else if load in, else if inc out+1.
+func (c *counter) tick() {
+	switch {
+	case c.p[3]&1 != 0:
+		c.value = 0
+	case c.p[1]&1 != 0:
+		c.value = c.p[0]
+	case c.p[2]&1 != 0:
+		c.value = c.out + 1
+	}
+}
+func (c *counter) tock()       { c.out = c.value }
+func (c *counter) invalidate() {}
+
+func (c *counter) get(index int) (uint16, bool) {
+	if index > 0 {
+		return 0, false
+	}
+	return c.value, true
+}
+
+func (c *counter) set(index int, v uint16) bool {
+	if index > 0 {
+		return false
+	}
+	c.value, c.out = v, v
+	return true
+}
+
+// ram is RAM8 ~ RAM16K and Screen: pins are in, load, address, out.
+// The write is committed at the end of the cycle.
+type ram struct {
+	p       []uint16
+	words   []uint16
+	pending bool
+	addr    uint16
+	value   uint16
+}
+
+func (r *ram) pins() []uint16 { return r.p }
+func (r *ram) eval()          { r.p[3] = r.words[int(r.p[2])%len(r.words)] }
+func (r *ram) tick() {
+	r.pending = r.p[1]&1 != 0
+	r.addr, r.value = r.p[2], r.p[0]
+}
+func (r *ram) tock() {
+	if r.pending {
+		r.words[int(r.addr)%len(r.words)] = r.value
+		r.pending = false
+	}
+}
+func (r *ram) invalidate() {}
+
+func (r *ram) get(index int) (uint16, bool) {
+	if index < 0 || index >= len(r.words) {
+		return 0, false
+	}
+	return r.words[index], true
+}
+
+func (r *ram) set(index int, v uint16) bool {
+	if index < 0 || index >= len(r.words) {
+		return false
+	}
+	r.words[index] = v
+	return true
+}
+
+type rom struct {
+	p     []uint16
+	words []uint16
+}
+
+func (r *rom) pins() []uint16 { return r.p }
+func (r *rom) eval()          { r.p[1] = r.words[int(r.p[0])%len(r.words)] }
+func (r *rom) tick()          {}
+func (r *rom) tock()          {}
+func (r *rom) invalidate()    {}
+
+func (r *rom) get(index int) (uint16, bool) {
+	if index < 0 || index >= len(r.words) {
+		return 0, false
+	}
+	return r.words[index], true
+}
+
+func (r *rom) set(index int, v uint16) bool {
+	if index < 0 || index >= len(r.words) {
+		return false
+	}
+	r.words[index] = v
+	return true
+}
+
+// load reads a .hack file into the ROM.
+func (r *rom) load(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	clear(r.words)
+	i := 0
+	for n, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(line) != 16 || strings.Trim(line, "01") != "" {
+			return fmt.Errorf("%v:%v: invalid instruction %q", path, n+1, line)
+		}
+		if i >= len(r.words) {
+			return fmt.Errorf("%v: program is too large for ROM32K", path)
+		}
+		var w uint16
+		for _, b := range line {
+			w = w<<1 | uint16(b-'0')
+		}
+		r.words[i] = w
+		i++
+	}
+	return nil
+}
+
+type keyboard struct {
+	p   []uint16
+	env *env
+}
+
+func (k *keyboard) pins() []uint16 { return k.p }
+func (k *keyboard) eval()          { k.p[0] = k.env.keyboard }
+func (k *keyboard) tick()          {}
+func (k *keyboard) tock()          {}
+func (k *keyboard) invalidate()    {}
+
+func (k *keyboard) get(index int) (uint16, bool) {
+	if index > 0 {
+		return 0, false
+	}
+	return k.env.keyboard, true
+}
+
+func (k *keyboard) set(index int, v uint16) bool {
+	if index > 0 {
+		return false
+	}
+	k.env.keyboard = v
+	return true
+}
+
+func b2u(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func mux16(sel uint16, ins ...uint16) uint16 {
+	return ins[int(sel)%len(ins)]
+}
+
+func alu(x, y uint16, zx, nx, zy, ny, f, no bool) uint16 {
+	if zx {
+		x = 0
+	}
+	if nx {
+		x = ^x
+	}
+	if zy {
+		y = 0
+	}
+	if ny {
+		y = ^y
+	}
+	var out uint16
+	if f {
+		out = x + y
+	} else {
+		out = x & y
+	}
+	if no {
+		out = ^out
+	}
+	return out
+}
+
+func init() {
+	defineGate("Nand", pins(bit("a"), bit("b")), pins(bit("out")), func(p []uint16) {
+		p[2] = ^(p[0] & p[1]) & 1
+	})
+	defineGate("Not", pins(bit("in")), pins(bit("out")), func(p []uint16) {
+		p[1] = ^p[0] & 1
+	})
+	defineGate("And", pins(bit("a"), bit("b")), pins(bit("out")), func(p []uint16) {
+		p[2] = p[0] & p[1]
+	})
+	defineGate("Or", pins(bit("a"), bit("b")), pins(bit("out")), func(p []uint16) {
+		p[2] = p[0] | p[1]
+	})
+	defineGate("Xor", pins(bit("a"), bit("b")), pins(bit("out")), func(p []uint16) {
+		p[2] = p[0] ^ p[1]
+	})
+	defineGate("Mux", pins(bit("a"), bit("b"), bit("sel")), pins(bit("out")), func(p []uint16) {
+		p[3] = mux16(p[2], p[0], p[1])
+	})
+	defineGate("DMux", pins(bit("in"), bit("sel")), pins(bit("a"), bit("b")), func(p []uint16) {
+		p[2] = p[0] * b2u(p[1] == 0)
+		p[3] = p[0] * b2u(p[1] == 1)
+	})
+	defineGate("Not16", pins(bus("in", 16)), pins(bus("out", 16)), func(p []uint16) {
+		p[1] = ^p[0]
+	})
+	defineGate("And16", pins(bus("a", 16), bus("b", 16)), pins(bus("out", 16)), func(p []uint16) {
+		p[2] = p[0] & p[1]
+	})
+	defineGate("Or16", pins(bus("a", 16), bus("b", 16)), pins(bus("out", 16)), func(p []uint16) {
+		p[2] = p[0] | p[1]
+	})
+	defineGate("Mux16", pins(bus("a", 16), bus("b", 16), bit("sel")), pins(bus("out", 16)), func(p []uint16) {
+		p[3] = mux16(p[2], p[0], p[1])
+	})
+	defineGate("Or8Way", pins(bus("in", 8)), pins(bit("out")), func(p []uint16) {
+		p[1] = b2u(p[0] != 0)
+	})
+	defineGate("Mux4Way16",
+		pins(bus("a", 16), bus("b", 16), bus("c", 16), bus("d", 16), bus("sel", 2)),
+		pins(bus("out", 16)),
+		func(p []uint16) {
+			p[5] = mux16(p[4], p[0:4]...)
+		})
+	defineGate("Mux8Way16",
+		pins(bus("a", 16), bus("b", 16), bus("c", 16), bus("d", 16),
+			bus("e", 16), bus("f", 16), bus("g", 16), bus("h", 16), bus("sel", 3)),
+		pins(bus("out", 16)),
+		func(p []uint16) {
+			p[9] = mux16(p[8], p[0:8]...)
+		})
+	defineGate("DMux4Way", pins(bit("in"), bus("sel", 2)), pins(bit("a"), bit("b"), bit("c"), bit("d")), func(p []uint16) {
+		for i := 0; i < 4; i++ {
+			p[2+i] = p[0] * b2u(int(p[1]) == i)
+		}
+	})
+	defineGate("DMux8Way",
+		pins(bit("in"), bus("sel", 3)),
+		pins(bit("a"), bit("b"), bit("c"), bit("d"), bit("e"), bit("f"), bit("g"), bit("h")),
+		func(p []uint16) {
+			for i := 0; i < 8; i++ {
+				p[2+i] = p[0] * b2u(int(p[1]) == i)
+			}
+		})
+	defineGate("HalfAdder", pins(bit("a"), bit("b")), pins(bit("sum"), bit("carry")), func(p []uint16) {
+		p[2] = p[0] ^ p[1]
+		p[3] = p[0] & p[1]
+	})
+	defineGate("FullAdder", pins(bit("a"), bit("b"), bit("c")), pins(bit("sum"), bit("carry")), func(p []uint16) {
+		s := p[0] + p[1] + p[2]
+		p[3] = s & 1
+		p[4] = s >> 1
+	})
+	defineGate("Add16", pins(bus("a", 16), bus("b", 16)), pins(bus("out", 16)), func(p []uint16) {
+		p[2] = p[0] + p[1]
+	})
+	defineGate("Inc16", pins(bus("in", 16)), pins(bus("out", 16)), func(p []uint16) {
+		p[1] = p[0] + 1
+	})
+	defineGate("ALU",
+		pins(bus("x", 16), bus("y", 16), bit("zx"), bit("nx"), bit("zy"), bit("ny"), bit("f"), bit("no")),
+		pins(bus("out", 16), bit("zr"), bit("ng")),
+		func(p []uint16) {
+			out := alu(p[0], p[1], p[2] != 0, p[3] != 0, p[4] != 0, p[5] != 0, p[6] != 0, p[7] != 0)
+			p[8] = out
+			p[9] = b2u(out == 0)
+			p[10] = out >> 15
+		})
+
+	defineBuiltin("DFF", pins(bit("in")), pins(bit("out")), []string{"in"}, true, func(e *env) instance {
+		return &register{p: make([]uint16, 2), mask: 1, alwaysLoad: true}
+	})
+	defineBuiltin("Bit", pins(bit("in"), bit("load")), pins(bit("out")), []string{"in", "load"}, true, func(e *env) instance {
+		return &register{p: make([]uint16, 3), mask: 1}
+	})
+	for _, name := range []string{"Register", "ARegister", "DRegister"} {
+		defineBuiltin(name, pins(bus("in", 16), bit("load")), pins(bus("out", 16)), []string{"in", "load"}, true, func(e *env) instance {
+			return &register{p: make([]uint16, 3), mask: 0xffff}
+		})
+	}
+	defineBuiltin("PC",
+		pins(bus("in", 16), bit("load"), bit("inc"), bit("reset")),
+		pins(bus("out", 16)),
+		[]string{"in", "load", "inc", "reset"}, true,
+		func(e *env) instance {
+			return &counter{p: make([]uint16, 5)}
+		})
+	for _, r := range []struct {
+		name  string
+		width int
+	}{
+		{"RAM8", 3}, {"RAM64", 6}, {"RAM512", 9}, {"RAM4K", 12}, {"RAM16K", 14}, {"Screen", 13},
+	} {
+		size := 1 << r.width
+		defineBuiltin(r.name,
+			pins(bus("in", 16), bit("load"), bus("address", r.width)),
+			pins(bus("out", 16)),
+			[]string{"in", "load"}, true,
+			func(e *env) instance {
+				return &ram{p: make([]uint16, 4), words: make([]uint16, size)}
+			})
+	}
+	defineBuiltin("ROM32K", pins(bus("address", 15)), pins(bus("out", 16)), nil, false, func(e *env) instance {
+		return &rom{p: make([]uint16, 2), words: make([]uint16, 32768)}
+	})
+	// Keyboard has no clock, but is stateful because its output changes between evaluations.
+	defineBuiltin("Keyboard", nil, pins(bus("out", 16)), nil, true, func(e *env) instance {
+		return &keyboard{p: make([]uint16, 1), env: e}
+	})
+}
@@ -0,0 +1,125 @@
+package hdl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diagnostic is a problem found in an .hdl file before simulation.
+// Warnings do not stop the chip from being simulated.
+type Diagnostic struct {
+	Path    string
+	Line    int
+	Warning bool
+	Msg     string
+}
+
+func (d Diagnostic) String() string {
+	if d.Warning {
+		return fmt.Sprintf("%v:%v: warning: %v", d.Path, d.Line, d.Msg)
+	}
+	return fmt.Sprintf("%v:%v: %v", d.Path, d.Line, d.Msg)
+}
+
+// Diagnostics is the error returned by Loader.LoadFile for a chip with errors.
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Errors returns the diagnostics that are not warnings.
+func (ds Diagnostics) Errors() Diagnostics {
+	var errs Diagnostics
+	for _, d := range ds {
+		if !d.Warning {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+// unconnected warns about chip pins, part inputs and internal pins that are
+// not connected to anything. The simulator reads unconnected inputs as false
+// and leaves undriven outputs at 0, which is rarely intended.
+func (c *Chip) unconnected(decl *ChipDecl) Diagnostics {
+	var diags Diagnostics
+	warnf := func(line int, format string, args ...any) {
+		diags = append(diags, Diagnostic{Path: c.Path, Line: line, Warning: true, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	used := make([]uint16, c.sigTrue()+1)
+	for _, p := range c.Parts {
+		connected := make([]uint16, len(p.Chip.Inputs))
+		for _, conn := range p.Conns {
+			used[conn.Sig] |= bitMask(conn.SigLo, conn.Width)
+			if conn.In {
+				connected[conn.Pin] |= bitMask(conn.PinLo, conn.Width)
+			}
+		}
+		for i, pin := range p.Chip.Inputs {
+			if missing := bitMask(0, pin.Width) &^ connected[i]; missing != 0 {
+				warnf(p.Line, "input pin %v of %v is not connected", bitRanges(pin.Name, missing, pin.Width), p.Chip.Name)
+			}
+		}
+	}
+
+	for i, pin := range c.Inputs {
+		if missing := bitMask(0, pin.Width) &^ used[i]; missing != 0 {
+			warnf(decl.Inputs[i].Line, "input pin %v is not used", bitRanges(pin.Name, missing, pin.Width))
+		}
+	}
+	for i, pin := range c.Outputs {
+		if missing := bitMask(0, pin.Width) &^ used[len(c.Inputs)+i]; missing != 0 {
+			warnf(decl.Outputs[i].Line, "output pin %v is not connected", bitRanges(pin.Name, missing, pin.Width))
+		}
+	}
+
+	// An internal pin is used once its driver and a reader are connected.
+	readers := make([]int, len(c.Wires))
+	lines := make([]int, len(c.Wires))
+	nIn, nOut := len(c.Inputs), len(c.Outputs)
+	for _, p := range c.Parts {
+		for _, conn := range p.Conns {
+			if conn.Sig < nIn+nOut || conn.Sig >= c.sigFalse() {
+				continue
+			}
+			if conn.In {
+				readers[conn.Sig-nIn-nOut]++
+			} else {
+				lines[conn.Sig-nIn-nOut] = conn.Decl.Line
+			}
+		}
+	}
+	for i, w := range c.Wires {
+		if readers[i] == 0 {
+			warnf(lines[i], "internal pin %q is not used", w.Name)
+		}
+	}
+	return diags
+}
+
+// bitRanges names the bits of mask, like "a" when every bit is set or
+// "a[0..3], a[8]" otherwise.
+func bitRanges(name string, mask uint16, width int) string {
+	if mask == bitMask(0, width) {
+		return fmt.Sprintf("%q", name)
+	}
+	var refs []string
+	for lo := 0; lo < width; lo++ {
+		if mask&(1<<lo) == 0 {
+			continue
+		}
+		hi := lo
+		for hi+1 < width && mask&(1<<(hi+1)) != 0 {
+			hi++
+		}
+		refs = append(refs, fmt.Sprintf("%q", PinRef{Name: name, Lo: lo, Hi: hi}))
+		lo = hi
+	}
+	return strings.Join(refs, ", ")
+}
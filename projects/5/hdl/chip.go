@@ -0,0 +1,658 @@
+package hdl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Pin is an input, output or internal pin of a chip.
+type Pin struct {
+	Name  string
+	Width int
+}
+
+// Chip is a resolved chip: either a builtin implemented in Go or a chip
+// built from an .hdl file whose parts have been resolved to other Chips.
+type Chip struct {
+	Name    string
+	Path    string // empty for builtins
+	Inputs  []Pin
+	Outputs []Pin
+	Wires   []Pin   // internal pins, nil for builtins
+	Parts   []*Part // nil for builtins
+
+	// Stateful chips contain a DFF or a builtin memory somewhere below them.
+	Stateful bool
+
+	// comb[o] is the set of inputs (as bit mask) that output o depends on
+	// without going through a clock. Inputs outside every mask are clocked.
+	comb []uint64
+	// order is the evaluation order of Parts. When iterate is set the parts
+	// depend on each other through different pins and order is repeated until
+	// no signal changes.
+	order   []int
+	iterate bool
+
+	newBuiltin func(*env) instance
+}
+
+func (c *Chip) IsBuiltin() bool {
+	return c.newBuiltin != nil
+}
+
+func (c *Chip) String() string {
+	return c.Name
+}
+
+func (c *Chip) inputIndex(name string) int {
+	for i, p := range c.Inputs {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Chip) outputIndex(name string) int {
+	for i, p := range c.Outputs {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Chip) wireIndex(name string) int {
+	for i, p := range c.Wires {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// isCombInput reports whether input i reaches some output without a clock in between.
+func (c *Chip) isCombInput(i int) bool {
+	for _, m := range c.comb {
+		if m&(1<<i) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Part is a chip instance in the PARTS section of another chip.
+type Part struct {
+	Chip  *Chip
+	Line  int
+	Conns []Conn
+}
+
+// Conn is a resolved connection between a part pin and a signal of the enclosing chip.
+// Signals are numbered inputs, outputs, internal pins, then the constants false and true.
+type Conn struct {
+	Decl ConnDecl
+	// In is set when the value flows into the part.
+	In bool
+	// Pin is the index of the part pin, inputs first then outputs.
+	Pin   int
+	PinLo int
+	Width int
+	Sig   int
+	SigLo int
+}
+
+func (c *Chip) sigFalse() int {
+	return len(c.Inputs) + len(c.Outputs) + len(c.Wires)
+}
+
+func (c *Chip) sigTrue() int {
+	return c.sigFalse() + 1
+}
+
+// Loader loads .hdl files and resolves their parts. A part is looked up in the
+// directory of the chip that uses it, then in Dirs, then among the builtin chips.
+type Loader struct {
+	Dirs []string
+
+	chips map[string]*Chip
+	// warnings of the chips loaded so far, keyed like chips.
+	warnings map[string]Diagnostics
+	loading  map[string]bool
+}
+
+func NewLoader(dirs ...string) *Loader {
+	return &Loader{
+		Dirs:     dirs,
+		chips:    make(map[string]*Chip),
+		warnings: make(map[string]Diagnostics),
+		loading:  make(map[string]bool),
+	}
+}
+
+// LoadFile loads the chip defined in the .hdl file at path. When the chip has
+// errors, the returned error is a Diagnostics holding all of them.
+func (l *Loader) LoadFile(path string) (*Chip, error) {
+	c, diags, err := l.load(path)
+	if err != nil {
+		return nil, err
+	}
+	if errs := diags.Errors(); len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// Check loads the chip at path and returns everything wrong with it, warnings
+// included, without stopping at the first error. err is only set when the
+// file cannot be read or parsed.
+func (l *Loader) Check(path string) (Diagnostics, error) {
+	_, diags, err := l.load(path)
+	return diags, err
+}
+
+func (l *Loader) load(path string) (*Chip, Diagnostics, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c, ok := l.chips[abs]; ok {
+		return c, l.warnings[abs], nil
+	}
+	if l.loading[abs] {
+		return nil, nil, fmt.Errorf("%v: chip uses itself", path)
+	}
+	l.loading[abs] = true
+	defer delete(l.loading, abs)
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	decl, err := Parse(string(src))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %w", path, err)
+	}
+	if want := strings.TrimSuffix(filepath.Base(path), ".hdl"); decl.Name != want {
+		return nil, nil, fmt.Errorf("%v: chip name %q does not match file name", path, decl.Name)
+	}
+	c, diags := l.build(decl, path)
+	if len(diags.Errors()) == 0 {
+		l.chips[abs] = c
+		l.warnings[abs] = diags
+	}
+	return c, diags, nil
+}
+
+// Resolve finds the chip called name as seen from a chip in dir.
+func (l *Loader) Resolve(name, dir string) (*Chip, error) {
+	if b, ok := builtins[name]; ok && primitives[name] {
+		return b, nil
+	}
+	for _, d := range append([]string{dir}, l.Dirs...) {
+		path := filepath.Join(d, name+".hdl")
+		if _, err := os.Stat(path); err == nil {
+			return l.LoadFile(path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if b, ok := builtins[name]; ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("chip %q not found", name)
+}
+
+// build resolves the parts of decl and connects them. Problems are collected
+// rather than returned one at a time; the chip is only usable when there is
+// no error among them.
+func (l *Loader) build(decl *ChipDecl, path string) (*Chip, Diagnostics) {
+	var diags Diagnostics
+	errorf := func(line int, format string, args ...any) {
+		diags = append(diags, Diagnostic{Path: path, Line: line, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if decl.Builtin != "" {
+		b, ok := builtins[decl.Builtin]
+		if !ok {
+			errorf(1, "unknown builtin chip %q", decl.Builtin)
+		}
+		return b, diags
+	}
+
+	c := &Chip{Name: decl.Name, Path: path}
+	seen := make(map[string]bool)
+	for i, p := range append(append([]PinDecl{}, decl.Inputs...), decl.Outputs...) {
+		switch {
+		case seen[p.Name]:
+			errorf(p.Line, "pin %q is declared twice", p.Name)
+			continue
+		case p.Name == "true" || p.Name == "false":
+			errorf(p.Line, "%q cannot be used as a pin name", p.Name)
+			continue
+		}
+		seen[p.Name] = true
+		if i < len(decl.Inputs) {
+			c.Inputs = append(c.Inputs, Pin{Name: p.Name, Width: p.Width})
+		} else {
+			c.Outputs = append(c.Outputs, Pin{Name: p.Name, Width: p.Width})
+		}
+	}
+	if len(c.Inputs) > 64 {
+		errorf(1, "chip %v has more than 64 inputs", c.Name)
+		return nil, diags
+	}
+
+	// Parts that cannot be resolved stay nil and their connections are skipped.
+	dir := filepath.Dir(path)
+	parts := make([]*Part, len(decl.Parts))
+	for i, pd := range decl.Parts {
+		pc, err := l.Resolve(pd.Name, dir)
+		var errs Diagnostics
+		switch {
+		case errors.As(err, &errs):
+			errorf(pd.Line, "chip %v has errors", pd.Name)
+			diags = append(diags, errs...)
+			continue
+		case err != nil:
+			errorf(pd.Line, "%v", err)
+			continue
+		}
+		parts[i] = &Part{Chip: pc, Line: pd.Line}
+	}
+
+	// Internal pins are defined by part outputs, so they are collected before
+	// connecting any part input to them.
+	driver := make(map[int]int) // internal pin -> part
+	for i, pd := range decl.Parts {
+		part := parts[i]
+		if part == nil {
+			continue
+		}
+		for _, cd := range pd.Conns {
+			if part.Chip.inputIndex(cd.Pin.Name) >= 0 || part.Chip.outputIndex(cd.Pin.Name) < 0 {
+				continue
+			}
+			v := cd.Value
+			if v.IsConst() || seen[v.Name] {
+				continue
+			}
+			if v.HasRange() {
+				errorf(cd.Line, "sub bus of internal pin %q is not allowed", v.Name)
+				continue
+			}
+			width, err := pinWidth(part.Chip.Outputs[part.Chip.outputIndex(cd.Pin.Name)], cd.Pin)
+			if err != nil {
+				// Reported when connecting the part.
+				continue
+			}
+			if w := c.wireIndex(v.Name); w >= 0 {
+				errorf(cd.Line, "internal pin %q is driven twice", v.Name)
+				continue
+			}
+			driver[len(c.Wires)] = i
+			c.Wires = append(c.Wires, Pin{Name: v.Name, Width: width})
+		}
+	}
+
+	nIn, nOut := len(c.Inputs), len(c.Outputs)
+	outDriven := make([]uint16, nOut)
+	for i, pd := range decl.Parts {
+		part := parts[i]
+		if part == nil {
+			continue
+		}
+		inConnected := make([]uint16, len(part.Chip.Inputs))
+		for _, cd := range pd.Conns {
+			conn := Conn{Decl: cd}
+
+			var pin Pin
+			if in := part.Chip.inputIndex(cd.Pin.Name); in >= 0 {
+				conn.In, conn.Pin, pin = true, in, part.Chip.Inputs[in]
+			} else if out := part.Chip.outputIndex(cd.Pin.Name); out >= 0 {
+				conn.Pin, pin = len(part.Chip.Inputs)+out, part.Chip.Outputs[out]
+			} else {
+				errorf(cd.Line, "chip %v has no pin %q", part.Chip.Name, cd.Pin.Name)
+				continue
+			}
+			width, err := pinWidth(pin, cd.Pin)
+			if err != nil {
+				errorf(cd.Line, "%v: %v", part.Chip.Name, err)
+				continue
+			}
+			conn.Width = width
+			if cd.Pin.HasRange() {
+				conn.PinLo = cd.Pin.Lo
+			}
+			if conn.In {
+				m := bitMask(conn.PinLo, width)
+				if inConnected[conn.Pin]&m != 0 {
+					errorf(cd.Line, "pin %q of %v is connected twice", cd.Pin, part.Chip.Name)
+					continue
+				}
+				inConnected[conn.Pin] |= m
+			}
+
+			v := cd.Value
+			switch {
+			case v.IsConst():
+				if !conn.In {
+					errorf(cd.Line, "output pin %q cannot be connected to %q", cd.Pin, v.Name)
+					continue
+				}
+				if v.HasRange() {
+					errorf(cd.Line, "sub bus of %q is not allowed", v.Name)
+					continue
+				}
+				conn.Sig = c.sigFalse()
+				if v.Name == "true" {
+					conn.Sig = c.sigTrue()
+				}
+			case c.inputIndex(v.Name) >= 0:
+				if !conn.In {
+					errorf(cd.Line, "input pin %q of chip %v cannot be driven by a part", v.Name, c.Name)
+					continue
+				}
+				idx := c.inputIndex(v.Name)
+				if conn.SigLo, err = checkWidth(c.Inputs[idx], v, width); err != nil {
+					errorf(cd.Line, "%v", err)
+					continue
+				}
+				conn.Sig = idx
+			case c.outputIndex(v.Name) >= 0:
+				if conn.In {
+					errorf(cd.Line, "output pin %q of chip %v cannot be used as a part input", v.Name, c.Name)
+					continue
+				}
+				idx := c.outputIndex(v.Name)
+				if conn.SigLo, err = checkWidth(c.Outputs[idx], v, width); err != nil {
+					errorf(cd.Line, "%v", err)
+					continue
+				}
+				m := bitMask(conn.SigLo, width)
+				if outDriven[idx]&m != 0 {
+					errorf(cd.Line, "output pin %q is driven twice", v)
+					continue
+				}
+				outDriven[idx] |= m
+				conn.Sig = nIn + idx
+			default:
+				w := c.wireIndex(v.Name)
+				if w < 0 {
+					if conn.In {
+						errorf(cd.Line, "internal pin %q has no source", v.Name)
+					}
+					continue
+				}
+				if v.HasRange() {
+					if conn.In {
+						errorf(cd.Line, "sub bus of internal pin %q is not allowed", v.Name)
+					}
+					continue
+				}
+				if c.Wires[w].Width != width {
+					errorf(cd.Line, "width of %q is %v, but pin %q is %v", v.Name, c.Wires[w].Width, cd.Pin, width)
+					continue
+				}
+				conn.Sig = nIn + nOut + w
+			}
+			part.Conns = append(part.Conns, conn)
+		}
+	}
+	if len(diags.Errors()) > 0 {
+		return nil, diags
+	}
+	c.Parts = parts
+	diags = append(diags, c.unconnected(decl)...)
+
+	if loop := c.sortParts(driver); loop != nil {
+		names := make([]string, len(loop)+1)
+		for i, sig := range loop {
+			names[i] = c.sigName(sig)
+		}
+		names[len(loop)] = names[0]
+		line := c.Parts[driver[loop[0]-nIn-nOut]].Line
+		errorf(line, "combinational loop not broken by a DFF: %v", strings.Join(names, " -> "))
+		return nil, diags
+	}
+	c.computeComb()
+	for _, p := range c.Parts {
+		c.Stateful = c.Stateful || p.Chip.Stateful
+	}
+	return c, diags
+}
+
+// pinWidth is the width of ref, a possibly sub bus reference to pin.
+func pinWidth(pin Pin, ref PinRef) (int, error) {
+	if !ref.HasRange() {
+		return pin.Width, nil
+	}
+	if ref.Hi >= pin.Width {
+		return 0, fmt.Errorf("sub bus %v is out of width %v", ref, pin.Width)
+	}
+	return ref.Hi - ref.Lo + 1, nil
+}
+
+// checkWidth checks that ref, a reference to pin of the enclosing chip,
+// has the given width and returns its lowest bit.
+func checkWidth(pin Pin, ref PinRef, width int) (int, error) {
+	w, err := pinWidth(pin, ref)
+	if err != nil {
+		return 0, err
+	}
+	if w != width {
+		return 0, fmt.Errorf("width of %v is %v, but connected pin is %v", ref, w, width)
+	}
+	if ref.HasRange() {
+		return ref.Lo, nil
+	}
+	return 0, nil
+}
+
+func bitMask(lo, width int) uint16 {
+	return uint16((1<<width)-1) << lo
+}
+
+// sortParts orders the parts so that every part is evaluated after the parts
+// driving it. Edges into clocked inputs are dropped if needed to break cycles;
+// a cycle that remains is a combinational loop, returned as the signals on it.
+func (c *Chip) sortParts(driver map[int]int) []int {
+	n := len(c.Parts)
+	nIn, nOut := len(c.Inputs), len(c.Outputs)
+	hard := make([][]bool, n)
+	soft := make([][]bool, n)
+	for i := range hard {
+		hard[i] = make([]bool, n)
+		soft[i] = make([]bool, n)
+	}
+	for i, p := range c.Parts {
+		for _, conn := range p.Conns {
+			if !conn.In || conn.Sig < nIn+nOut || conn.Sig >= c.sigFalse() {
+				continue
+			}
+			d := driver[conn.Sig-nIn-nOut]
+			if p.Chip.isCombInput(conn.Pin) {
+				hard[d][i] = true
+			} else {
+				soft[d][i] = true
+			}
+		}
+	}
+
+	if order, ok := topoSort(n, hard, soft); ok {
+		c.order = order
+		return nil
+	}
+	if order, ok := topoSort(n, hard, nil); ok {
+		c.order = order
+		return nil
+	}
+	// Parts may still depend on each other through different pins, like CPU
+	// and Memory in Computer. That is fine as long as no signal depends on itself.
+	if loop := c.signalLoop(); loop != nil {
+		return loop
+	}
+	c.order = make([]int, n)
+	for i := range c.order {
+		c.order[i] = i
+	}
+	c.iterate = true
+	return nil
+}
+
+// signalLoop returns signals that depend combinationally on themselves, in
+// the order they drive each other, or nil if there is none.
+func (c *Chip) signalLoop() []int {
+	n := c.sigTrue() + 1
+	next := make([][]int, n)
+	for _, p := range c.Parts {
+		for _, in := range p.Conns {
+			if !in.In {
+				continue
+			}
+			for _, out := range p.Conns {
+				if !out.In && p.Chip.comb[out.Pin-len(p.Chip.Inputs)]&(1<<in.Pin) != 0 {
+					next[in.Sig] = append(next[in.Sig], out.Sig)
+				}
+			}
+		}
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, n)
+	var path []int
+	var visit func(s int) []int
+	visit = func(s int) []int {
+		state[s] = visiting
+		path = append(path, s)
+		for _, t := range next[s] {
+			if state[t] == visiting {
+				return path[slices.Index(path, t):]
+			}
+			if state[t] == unvisited {
+				if loop := visit(t); loop != nil {
+					return loop
+				}
+			}
+		}
+		state[s] = visited
+		path = path[:len(path)-1]
+		return nil
+	}
+	for s := 0; s < n; s++ {
+		if state[s] == unvisited {
+			if loop := visit(s); loop != nil {
+				return loop
+			}
+		}
+	}
+	return nil
+}
+
+// sigName returns the name of signal s as written in the .hdl file.
+func (c *Chip) sigName(s int) string {
+	nIn, nOut := len(c.Inputs), len(c.Outputs)
+	switch {
+	case s < nIn:
+		return c.Inputs[s].Name
+	case s < nIn+nOut:
+		return c.Outputs[s-nIn].Name
+	case s < c.sigFalse():
+		return c.Wires[s-nIn-nOut].Name
+	case s == c.sigFalse():
+		return "false"
+	default:
+		return "true"
+	}
+}
+
+// topoSort returns a stable topological order of n nodes for the union of
+// the given edge matrices.
+func topoSort(n int, edges ...[][]bool) ([]int, bool) {
+	indeg := make([]int, n)
+	for _, e := range edges {
+		if e == nil {
+			continue
+		}
+		for from := 0; from < n; from++ {
+			for to := 0; to < n; to++ {
+				if e[from][to] && from != to {
+					indeg[to]++
+				}
+			}
+		}
+	}
+	done := make([]bool, n)
+	order := make([]int, 0, n)
+	for len(order) < n {
+		next := -1
+		for i := 0; i < n; i++ {
+			if !done[i] && indeg[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, false
+		}
+		done[next] = true
+		order = append(order, next)
+		for _, e := range edges {
+			if e == nil {
+				continue
+			}
+			for to := 0; to < n; to++ {
+				if e[next][to] && next != to {
+					indeg[to]--
+				}
+			}
+		}
+	}
+	return order, true
+}
+
+// computeComb propagates which chip inputs reach each signal combinationally.
+func (c *Chip) computeComb() {
+	deps := make([]uint64, c.sigTrue()+1)
+	for i := range c.Inputs {
+		deps[i] = 1 << i
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, pi := range c.order {
+			p := c.Parts[pi]
+			inDeps := make([]uint64, len(p.Chip.Inputs))
+			for _, conn := range p.Conns {
+				if conn.In {
+					inDeps[conn.Pin] |= deps[conn.Sig]
+				}
+			}
+			for _, conn := range p.Conns {
+				if conn.In {
+					continue
+				}
+				var m uint64
+				for i, d := range inDeps {
+					if p.Chip.comb[conn.Pin-len(p.Chip.Inputs)]&(1<<i) != 0 {
+						m |= d
+					}
+				}
+				if deps[conn.Sig]|m != deps[conn.Sig] {
+					deps[conn.Sig] |= m
+					changed = true
+				}
+			}
+		}
+	}
+	c.comb = make([]uint64, len(c.Outputs))
+	for o := range c.Outputs {
+		c.comb[o] = deps[len(c.Inputs)+o]
+	}
+}
@@ -0,0 +1,67 @@
+package hdl
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var loadHDL = regexp.MustCompile(`(?m)^\s*load\s+(\w+\.hdl)`)
+
+// keyPrompt finds the key the script asks the user to hold down, like "hold down the 'K' key".
+var keyPrompt = regexp.MustCompile(`(?i)hold down (?:the )?'(.)'`)
+
+// TestGradeChips runs every hardware test script of projects 1 ~ 5 against the
+// chips in the repository. Chips whose PARTS section is still empty are skipped.
+func TestGradeChips(t *testing.T) {
+	var scripts []string
+	for _, dir := range []string{"../../1", "../../2", "../../3/a", "../../3/b", "../../5"} {
+		files, err := filepath.Glob(filepath.Join(dir, "*.tst"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts = append(scripts, files...)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no test scripts found")
+	}
+
+	for _, path := range scripts {
+		name := strings.TrimPrefix(path, "../../")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := loadHDL.FindSubmatch(src)
+			if m == nil {
+				t.Skip("not a chip test")
+			}
+			hdlSrc, err := os.ReadFile(filepath.Join(filepath.Dir(path), string(m[1])))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decl, err := Parse(string(hdlSrc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decl.Parts) == 0 && decl.Builtin == "" {
+				t.Skipf("%s is not implemented yet", m[1])
+			}
+
+			r := NewRunner(filepath.Dir(path))
+			r.OutDir = t.TempDir()
+			r.Echo = func(msg string) {
+				// Plays the user holding down the requested key.
+				if k := keyPrompt.FindStringSubmatch(msg); k != nil {
+					r.chip.sim.SetKeyboard(uint16(k[1][0]))
+				}
+			}
+			if err := r.RunFile(path); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
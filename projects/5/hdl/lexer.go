@@ -0,0 +1,93 @@
+package hdl
+
+import (
+	"fmt"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokSymbol
+	tokEOF
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	line  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of file"
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+// tokenize splits HDL source into identifiers, numbers and symbols.
+// Comments are dropped here so the parser only sees tokens.
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	rs := []rune(src)
+	line := 1
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case r == '\n':
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '/':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			start := line
+			i += 2
+			for ; i < len(rs) && !(rs[i] == '*' && i+1 < len(rs) && rs[i+1] == '/'); i++ {
+				if rs[i] == '\n' {
+					line++
+				}
+			}
+			if i >= len(rs) {
+				return nil, fmt.Errorf("line %v: unterminated comment", start)
+			}
+			i += 2
+		case r == '.' && i+1 < len(rs) && rs[i+1] == '.':
+			tokens = append(tokens, token{kind: tokSymbol, value: "..", line: line})
+			i += 2
+		case isIdentStart(r):
+			start := i
+			for i < len(rs) && isIdentPart(rs[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, value: string(rs[start:i]), line: line})
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(rs) && unicode.IsDigit(rs[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, value: string(rs[start:i]), line: line})
+		case r == '{' || r == '}' || r == '(' || r == ')' || r == '[' || r == ']' ||
+			r == ',' || r == ';' || r == '=' || r == ':':
+			tokens = append(tokens, token{kind: tokSymbol, value: string(r), line: line})
+			i++
+		default:
+			return nil, fmt.Errorf("line %v: invalid character %q", line, r)
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, line: line})
+	return tokens, nil
+}
+
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentPart(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
@@ -0,0 +1,300 @@
+package hdl
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// PinDecl is a pin declared in the IN or OUT section of a chip.
+type PinDecl struct {
+	Name  string
+	Width int
+	Line  int
+}
+
+// PinRef is one side of a connection: "a", "a[3]" or "a[0..7]".
+// Hi and Lo are -1 when no sub bus is given.
+type PinRef struct {
+	Name   string
+	Lo, Hi int
+}
+
+func (r PinRef) HasRange() bool {
+	return r.Lo >= 0
+}
+
+func (r PinRef) IsConst() bool {
+	return r.Name == "true" || r.Name == "false"
+}
+
+func (r PinRef) String() string {
+	switch {
+	case !r.HasRange():
+		return r.Name
+	case r.Lo == r.Hi:
+		return fmt.Sprintf("%v[%v]", r.Name, r.Lo)
+	default:
+		return fmt.Sprintf("%v[%v..%v]", r.Name, r.Lo, r.Hi)
+	}
+}
+
+// ConnDecl connects a pin of a part (Pin) to a pin of the enclosing chip,
+// an internal pin or a constant (Value).
+type ConnDecl struct {
+	Pin   PinRef
+	Value PinRef
+	Line  int
+}
+
+type PartDecl struct {
+	Name  string
+	Conns []ConnDecl
+	Line  int
+}
+
+// ChipDecl is the syntax tree of a single .hdl file.
+type ChipDecl struct {
+	Name    string
+	Inputs  []PinDecl
+	Outputs []PinDecl
+	Parts   []PartDecl
+	// Builtin is set by "BUILTIN Name;" instead of a PARTS section.
+	Builtin string
+	Clocked []string
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses the source of a .hdl file.
+func Parse(src string) (*ChipDecl, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	return p.parseChip()
+}
+
+func (p *parser) current() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("line %v: %v", t.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) expect(value string) (token, error) {
+	t := p.next()
+	if t.kind == tokEOF || t.value != value {
+		return t, p.errorf(t, "expected %q, but %v", value, t)
+	}
+	return t, nil
+}
+
+func (p *parser) ident() (token, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return t, p.errorf(t, "expected identifier, but %v", t)
+	}
+	return t, nil
+}
+
+func (p *parser) number() (int, error) {
+	t := p.next()
+	if t.kind != tokNumber {
+		return 0, p.errorf(t, "expected number, but %v", t)
+	}
+	n, err := strconv.Atoi(t.value)
+	if err != nil {
+		return 0, p.errorf(t, "invalid number %q", t.value)
+	}
+	return n, nil
+}
+
+func (p *parser) parseChip() (*ChipDecl, error) {
+	if _, err := p.expect("CHIP"); err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	chip := &ChipDecl{Name: name.value}
+
+	for {
+		t := p.next()
+		switch {
+		case t.value == "IN" && t.kind == tokIdent:
+			pins, err := p.parsePinDecls()
+			if err != nil {
+				return nil, err
+			}
+			chip.Inputs = append(chip.Inputs, pins...)
+		case t.value == "OUT" && t.kind == tokIdent:
+			pins, err := p.parsePinDecls()
+			if err != nil {
+				return nil, err
+			}
+			chip.Outputs = append(chip.Outputs, pins...)
+		case t.value == "PARTS" && t.kind == tokIdent:
+			if _, err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			for p.current().kind == tokIdent {
+				part, err := p.parsePart()
+				if err != nil {
+					return nil, err
+				}
+				chip.Parts = append(chip.Parts, part)
+			}
+		case t.value == "BUILTIN" && t.kind == tokIdent:
+			b, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			chip.Builtin = b.value
+			if _, err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case t.value == "CLOCKED" && t.kind == tokIdent:
+			for {
+				c, err := p.ident()
+				if err != nil {
+					return nil, err
+				}
+				chip.Clocked = append(chip.Clocked, c.value)
+				if sep := p.next(); sep.value == ";" {
+					break
+				} else if sep.value != "," {
+					return nil, p.errorf(sep, "expected \",\" or \";\", but %v", sep)
+				}
+			}
+		case t.value == "}" && t.kind == tokSymbol:
+			if end := p.current(); end.kind != tokEOF {
+				return nil, p.errorf(end, "unexpected %v after end of chip", end)
+			}
+			return chip, nil
+		default:
+			return nil, p.errorf(t, "unexpected %v in chip %v", t, chip.Name)
+		}
+	}
+}
+
+// parsePinDecls parses "a, b[16], c;" after IN or OUT.
+func (p *parser) parsePinDecls() ([]PinDecl, error) {
+	var pins []PinDecl
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		pin := PinDecl{Name: name.value, Width: 1, Line: name.line}
+		if p.current().value == "[" {
+			p.next()
+			w, err := p.number()
+			if err != nil {
+				return nil, err
+			}
+			if w < 1 || w > 16 {
+				return nil, p.errorf(name, "width of pin %q must be 1 ~ 16, but %v", name.value, w)
+			}
+			pin.Width = w
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		}
+		pins = append(pins, pin)
+
+		sep := p.next()
+		if sep.value == ";" {
+			return pins, nil
+		}
+		if sep.value != "," {
+			return nil, p.errorf(sep, "expected \",\" or \";\", but %v", sep)
+		}
+	}
+}
+
+// parsePart parses "Name(a=x, b[0..7]=y[8..15], ...);".
+func (p *parser) parsePart() (PartDecl, error) {
+	name, err := p.ident()
+	if err != nil {
+		return PartDecl{}, err
+	}
+	part := PartDecl{Name: name.value, Line: name.line}
+	if _, err := p.expect("("); err != nil {
+		return PartDecl{}, err
+	}
+	for {
+		line := p.current().line
+		pin, err := p.parsePinRef()
+		if err != nil {
+			return PartDecl{}, err
+		}
+		if _, err := p.expect("="); err != nil {
+			return PartDecl{}, err
+		}
+		value, err := p.parsePinRef()
+		if err != nil {
+			return PartDecl{}, err
+		}
+		part.Conns = append(part.Conns, ConnDecl{Pin: pin, Value: value, Line: line})
+
+		sep := p.next()
+		if sep.value == ")" {
+			break
+		}
+		if sep.value != "," {
+			return PartDecl{}, p.errorf(sep, "expected \",\" or \")\", but %v", sep)
+		}
+	}
+	if _, err := p.expect(";"); err != nil {
+		return PartDecl{}, err
+	}
+	return part, nil
+}
+
+func (p *parser) parsePinRef() (PinRef, error) {
+	name, err := p.ident()
+	if err != nil {
+		return PinRef{}, err
+	}
+	ref := PinRef{Name: name.value, Lo: -1, Hi: -1}
+	if p.current().value != "[" {
+		return ref, nil
+	}
+	p.next()
+	lo, err := p.number()
+	if err != nil {
+		return PinRef{}, err
+	}
+	hi := lo
+	if p.current().value == ".." {
+		p.next()
+		if hi, err = p.number(); err != nil {
+			return PinRef{}, err
+		}
+	}
+	if _, err := p.expect("]"); err != nil {
+		return PinRef{}, err
+	}
+	if hi < lo {
+		return PinRef{}, p.errorf(name, "invalid sub bus %v[%v..%v]", name.value, lo, hi)
+	}
+	ref.Lo, ref.Hi = lo, hi
+	return ref, nil
+}
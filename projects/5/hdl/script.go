@@ -0,0 +1,126 @@
+package hdl
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"nand2tetris-5/tst"
+)
+
+// Runner executes hardware test scripts.
+type Runner struct {
+	*tst.Runner
+	chip *chipMachine
+}
+
+func NewRunner(dir string) *Runner {
+	m := &chipMachine{dir: dir, loader: NewLoader()}
+	return &Runner{Runner: tst.NewRunner(m, dir), chip: m}
+}
+
+// RunFile parses and runs the .tst file at path.
+func RunFile(path string) error {
+	return NewRunner(filepath.Dir(path)).RunFile(path)
+}
+
+// chipMachine lets test scripts load a chip and drive its clock.
+type chipMachine struct {
+	dir    string
+	loader *Loader
+	sim    *Simulator
+	time   int
+	tickOn bool
+}
+
+func (m *chipMachine) Exec(cmd tst.Command) (bool, error) {
+	if cmd.Name == "load" {
+		if len(cmd.Args) != 1 {
+			return true, fmt.Errorf("load expects a file name")
+		}
+		c, err := m.loader.LoadFile(filepath.Join(m.dir, cmd.Args[0]))
+		if err != nil {
+			return true, err
+		}
+		m.sim = NewSimulator(c)
+		m.time, m.tickOn = 0, false
+		return true, nil
+	}
+	if m.sim == nil {
+		return true, fmt.Errorf("%v before load", cmd.Name)
+	}
+
+	switch cmd.Name {
+	case "eval":
+		m.sim.Eval()
+	case "tick":
+		m.sim.Tick()
+		m.tickOn = true
+	case "tock":
+		m.sim.Tock()
+		m.time++
+		m.tickOn = false
+	case "ticktock":
+		m.sim.Tick()
+		m.sim.Tock()
+		m.time++
+		m.tickOn = false
+	default:
+		// "<builtin part> load <file>", like "ROM32K load Max.hack"
+		if len(cmd.Args) == 2 && cmd.Args[0] == "load" {
+			return true, m.sim.Load(cmd.Name, filepath.Join(m.dir, cmd.Args[1]))
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *chipMachine) Width(name string) int {
+	if m.sim == nil {
+		return 16
+	}
+	if w := m.sim.Width(name); w > 0 {
+		return w
+	}
+	return 16
+}
+
+// Format shows the clock as "3" or "3+" between tick and tock.
+func (m *chipMachine) Format(name string) (string, bool) {
+	if name != "time" {
+		return "", false
+	}
+	t := strconv.Itoa(m.time)
+	if m.tickOn {
+		t += "+"
+	}
+	return t, true
+}
+
+func (m *chipMachine) Get(name string) (uint16, error) {
+	if m.sim == nil {
+		return 0, fmt.Errorf("%v read before load", name)
+	}
+	base, index, internal, err := tst.SplitVar(name)
+	if err != nil {
+		return 0, err
+	}
+	if internal {
+		return m.sim.GetInternal(base, index)
+	}
+	return m.sim.Get(name)
+}
+
+func (m *chipMachine) Set(name string, v uint16) error {
+	if m.sim == nil {
+		return fmt.Errorf("%v set before load", name)
+	}
+	base, index, internal, err := tst.SplitVar(name)
+	if err != nil {
+		return err
+	}
+	if internal {
+		return m.sim.SetInternal(base, index, v)
+	}
+	return m.sim.Set(name, v)
+}
@@ -0,0 +1,309 @@
+package hdl
+
+import (
+	"fmt"
+)
+
+// instance is a chip in a running simulation. pins holds the inputs followed
+// by the outputs.
+type instance interface {
+	pins() []uint16
+	// eval recomputes the outputs from the inputs and the current state.
+	eval()
+	// tick samples the inputs of clocked chips; tock commits them to the outputs.
+	tick()
+	tock()
+	// invalidate forces the next eval to recompute every part.
+	invalidate()
+}
+
+// env is shared by every instance of a simulation.
+type env struct {
+	keyboard uint16
+}
+
+// copyOp moves a bit field from one pin or signal to another.
+type copyOp struct {
+	src, dst     *uint16
+	srcLo, dstLo uint8
+	mask         uint16
+}
+
+// apply copies the field and reports whether the destination changed.
+func (c copyOp) apply() bool {
+	old := *c.dst
+	v := (*c.src >> c.srcLo) & c.mask
+	*c.dst = old&^(c.mask<<c.dstLo) | v<<c.dstLo
+	return *c.dst != old
+}
+
+func applyAll(ops []copyOp) bool {
+	changed := false
+	for _, op := range ops {
+		if op.apply() {
+			changed = true
+		}
+	}
+	return changed
+}
+
+type partInst struct {
+	chip *Chip
+	inst instance
+	in   []copyOp
+	out  []copyOp
+	// valid is cleared when the part has to be evaluated even if its inputs are unchanged.
+	valid bool
+}
+
+// composite is an instance of a chip built from an .hdl file. sig holds the
+// chip pins, the internal pins and the constants false and true.
+type composite struct {
+	chip  *Chip
+	sig   []uint16
+	parts []partInst
+}
+
+func instantiate(c *Chip, e *env) instance {
+	if c.IsBuiltin() {
+		return c.newBuiltin(e)
+	}
+	comp := &composite{
+		chip:  c,
+		sig:   make([]uint16, c.sigTrue()+1),
+		parts: make([]partInst, len(c.Parts)),
+	}
+	comp.sig[c.sigTrue()] = 0xffff
+	for i, p := range c.Parts {
+		inst := instantiate(p.Chip, e)
+		pi := partInst{chip: p.Chip, inst: inst}
+		pp := inst.pins()
+		for _, conn := range p.Conns {
+			mask := uint16((1 << conn.Width) - 1)
+			if conn.In {
+				pi.in = append(pi.in, copyOp{
+					src: &comp.sig[conn.Sig], srcLo: uint8(conn.SigLo),
+					dst: &pp[conn.Pin], dstLo: uint8(conn.PinLo),
+					mask: mask,
+				})
+			} else {
+				pi.out = append(pi.out, copyOp{
+					src: &pp[conn.Pin], srcLo: uint8(conn.PinLo),
+					dst: &comp.sig[conn.Sig], dstLo: uint8(conn.SigLo),
+					mask: mask,
+				})
+			}
+		}
+		comp.parts[i] = pi
+	}
+	return comp
+}
+
+func (c *composite) pins() []uint16 {
+	return c.sig[:len(c.chip.Inputs)+len(c.chip.Outputs)]
+}
+
+// eval evaluates the parts in dependency order, skipping parts whose inputs
+// have not changed since they were last evaluated.
+func (c *composite) eval() {
+	for c.evalOnce() && c.chip.iterate {
+	}
+}
+
+// evalOnce evaluates every part once and reports whether a signal changed.
+func (c *composite) evalOnce() bool {
+	changed := false
+	for _, i := range c.chip.order {
+		p := &c.parts[i]
+		if !applyAll(p.in) && p.valid {
+			continue
+		}
+		p.inst.eval()
+		if applyAll(p.out) {
+			changed = true
+		}
+		p.valid = true
+	}
+	return changed
+}
+
+func (c *composite) tick() {
+	for _, i := range c.chip.order {
+		p := &c.parts[i]
+		if !p.chip.Stateful {
+			continue
+		}
+		// A clocked part may come before the parts driving its clocked inputs,
+		// so it is settled again with the final values before sampling.
+		if applyAll(p.in) || !p.valid {
+			p.inst.eval()
+			applyAll(p.out)
+			p.valid = true
+		}
+		p.inst.tick()
+	}
+}
+
+func (c *composite) tock() {
+	for i := range c.parts {
+		p := &c.parts[i]
+		if p.chip.Stateful {
+			p.inst.tock()
+			p.valid = false
+		}
+	}
+}
+
+func (c *composite) invalidate() {
+	for i := range c.parts {
+		c.parts[i].valid = false
+		c.parts[i].inst.invalidate()
+	}
+}
+
+// Simulator runs a chip and gives access to its pins by name.
+type Simulator struct {
+	Chip *Chip
+	top  instance
+	env  *env
+}
+
+func NewSimulator(c *Chip) *Simulator {
+	e := &env{}
+	return &Simulator{Chip: c, top: instantiate(c, e), env: e}
+}
+
+// Eval propagates the current inputs through the combinational logic.
+func (s *Simulator) Eval() {
+	s.top.eval()
+}
+
+// Tick is the first half of a clock cycle: clocked chips sample their inputs.
+func (s *Simulator) Tick() {
+	s.top.eval()
+	s.top.tick()
+}
+
+// Tock is the second half of a clock cycle: clocked chips update their outputs.
+func (s *Simulator) Tock() {
+	s.top.tock()
+	s.top.eval()
+}
+
+// Set sets an input pin of the chip.
+func (s *Simulator) Set(name string, v uint16) error {
+	i := s.Chip.inputIndex(name)
+	if i < 0 {
+		return fmt.Errorf("chip %v has no input pin %q", s.Chip.Name, name)
+	}
+	s.top.pins()[i] = v & bitMask(0, s.Chip.Inputs[i].Width)
+	return nil
+}
+
+// Get returns the value of an input, output or internal pin of the chip.
+func (s *Simulator) Get(name string) (uint16, error) {
+	if i := s.Chip.inputIndex(name); i >= 0 {
+		return s.top.pins()[i], nil
+	}
+	if i := s.Chip.outputIndex(name); i >= 0 {
+		return s.top.pins()[len(s.Chip.Inputs)+i], nil
+	}
+	if comp, ok := s.top.(*composite); ok {
+		if i := s.Chip.wireIndex(name); i >= 0 {
+			return comp.sig[len(s.Chip.Inputs)+len(s.Chip.Outputs)+i], nil
+		}
+	}
+	return 0, fmt.Errorf("chip %v has no pin %q", s.Chip.Name, name)
+}
+
+// Width returns the width of a pin of the chip, or 0 if there is no such pin.
+func (s *Simulator) Width(name string) int {
+	for _, ps := range [][]Pin{s.Chip.Inputs, s.Chip.Outputs, s.Chip.Wires} {
+		for _, p := range ps {
+			if p.Name == name {
+				return p.Width
+			}
+		}
+	}
+	return 0
+}
+
+// findPart returns the first builtin part called name, searching the chip
+// hierarchy depth first.
+func (s *Simulator) findPart(name string) (instance, bool) {
+	var find func(c *Chip, inst instance) (instance, bool)
+	find = func(c *Chip, inst instance) (instance, bool) {
+		if c.Name == name && c.IsBuiltin() {
+			return inst, true
+		}
+		comp, ok := inst.(*composite)
+		if !ok {
+			return nil, false
+		}
+		for _, p := range comp.parts {
+			if found, ok := find(p.chip, p.inst); ok {
+				return found, true
+			}
+		}
+		return nil, false
+	}
+	return find(s.Chip, s.top)
+}
+
+// GetInternal reads the state of a builtin part, like "RAM16K[3]" or "DRegister[]".
+// index is -1 for "Name[]".
+func (s *Simulator) GetInternal(name string, index int) (uint16, error) {
+	inst, ok := s.findPart(name)
+	if !ok {
+		return 0, fmt.Errorf("chip %v has no builtin part %q", s.Chip.Name, name)
+	}
+	m, ok := inst.(memory)
+	if !ok {
+		return 0, fmt.Errorf("part %q has no internal state", name)
+	}
+	v, ok := m.get(index)
+	if !ok {
+		return 0, fmt.Errorf("index %v is out of range of %q", index, name)
+	}
+	return v, nil
+}
+
+// SetInternal writes the state of a builtin part.
+func (s *Simulator) SetInternal(name string, index int, v uint16) error {
+	inst, ok := s.findPart(name)
+	if !ok {
+		return fmt.Errorf("chip %v has no builtin part %q", s.Chip.Name, name)
+	}
+	m, ok := inst.(memory)
+	if !ok {
+		return fmt.Errorf("part %q has no internal state", name)
+	}
+	if !m.set(index, v) {
+		return fmt.Errorf("index %v is out of range of %q", index, name)
+	}
+	s.top.invalidate()
+	return nil
+}
+
+// Load lets a builtin part load a file, like "ROM32K load Max.hack".
+func (s *Simulator) Load(name, path string) error {
+	inst, ok := s.findPart(name)
+	if !ok {
+		return fmt.Errorf("chip %v has no builtin part %q", s.Chip.Name, name)
+	}
+	l, ok := inst.(loader)
+	if !ok {
+		return fmt.Errorf("part %q cannot load files", name)
+	}
+	if err := l.load(path); err != nil {
+		return err
+	}
+	s.top.invalidate()
+	return nil
+}
+
+// SetKeyboard sets the key seen by Keyboard parts.
+func (s *Simulator) SetKeyboard(key uint16) {
+	s.env.keyboard = key
+	s.top.invalidate()
+}
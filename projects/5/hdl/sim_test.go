@@ -0,0 +1,318 @@
+package hdl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeChips(t *testing.T, chips map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range chips {
+		if err := os.WriteFile(filepath.Join(dir, name+".hdl"), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestParse(t *testing.T) {
+	decl, err := Parse(`
+/** doc */
+CHIP Foo {
+    IN a[16], sel; // comment
+    OUT out[8];
+    PARTS:
+    Mux16(a=a, b[0..7]=true, b[8]=false, sel=sel, out[0..7]=out, out[15]=top);
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decl.Name != "Foo" || len(decl.Inputs) != 2 || decl.Inputs[0].Width != 16 || decl.Outputs[0].Width != 8 {
+		t.Fatalf("unexpected declaration %+v", decl)
+	}
+	conns := decl.Parts[0].Conns
+	if len(conns) != 6 {
+		t.Fatalf("expected 6 connections, but %v", len(conns))
+	}
+	if got := conns[1].Pin.String() + "=" + conns[1].Value.String(); got != "b[0..7]=true" {
+		t.Errorf("connection 1 is %v", got)
+	}
+	if got := conns[5].Pin.String(); got != "out[15]" {
+		t.Errorf("connection 5 is %v", got)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := Parse("CHIP Foo {\n IN a;\n OUT out;\n PARTS:\n Not(in=a out=out);\n}")
+	if err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Fatalf("expected error at line 5, but %v", err)
+	}
+}
+
+func TestCombinational(t *testing.T) {
+	c, err := NewLoader().LoadFile("../../2/ALU.hdl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSimulator(c)
+	tests := []struct {
+		x, y                  uint16
+		zx, nx, zy, ny, f, no uint16
+		out, zr, ng           uint16
+	}{
+		{17, 3, 0, 0, 0, 0, 1, 0, 20, 0, 0}, // x+y
+		{17, 3, 0, 1, 0, 0, 1, 1, 14, 0, 0}, // x-y
+		{17, 17, 0, 1, 0, 0, 1, 1, 0, 1, 0}, // x-y = 0
+		{0xff00, 0x0ff0, 0, 0, 0, 0, 0, 0, 0x0f00, 0, 0},
+	}
+	for _, tt := range tests {
+		for name, v := range map[string]uint16{"x": tt.x, "y": tt.y, "zx": tt.zx, "nx": tt.nx, "zy": tt.zy, "ny": tt.ny, "f": tt.f, "no": tt.no} {
+			if err := s.Set(name, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.Eval()
+		out, _ := s.Get("out")
+		zr, _ := s.Get("zr")
+		ng, _ := s.Get("ng")
+		if out != tt.out || zr != tt.zr || ng != tt.ng {
+			t.Errorf("ALU(%+v) = %x %v %v", tt, out, zr, ng)
+		}
+	}
+}
+
+func TestClocked(t *testing.T) {
+	c, err := NewLoader().LoadFile("../../3/a/PC.hdl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSimulator(c)
+	cycle := func() uint16 {
+		s.Tick()
+		s.Tock()
+		v, _ := s.Get("out")
+		return v
+	}
+	s.Set("inc", 1)
+	for want := uint16(1); want <= 3; want++ {
+		if got := cycle(); got != want {
+			t.Fatalf("out = %v, want %v", got, want)
+		}
+	}
+	s.Set("in", 100)
+	s.Set("load", 1)
+	s.Tick()
+	if v, _ := s.Get("out"); v != 3 {
+		t.Fatalf("out changed on tick: %v", v)
+	}
+	s.Tock()
+	if v, _ := s.Get("out"); v != 100 {
+		t.Fatalf("out = %v, want 100", v)
+	}
+	s.Set("reset", 1)
+	if got := cycle(); got != 0 {
+		t.Fatalf("out = %v after reset", got)
+	}
+}
+
+func TestSubBusAndConstants(t *testing.T) {
+	dir := writeChips(t, map[string]string{
+		"Swap": `CHIP Swap {
+    IN in[16];
+    OUT out[16], low[8], msb;
+    PARTS:
+    Or16(a[0..7]=in[8..15], a[8..15]=in[0..7], b=false, out=out, out[0..7]=low, out[15]=msb);
+}`,
+	})
+	c, err := NewLoader().LoadFile(filepath.Join(dir, "Swap.hdl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSimulator(c)
+	s.Set("in", 0x12f4)
+	s.Eval()
+	out, _ := s.Get("out")
+	low, _ := s.Get("low")
+	msb, _ := s.Get("msb")
+	if out != 0xf412 || low != 0x12 || msb != 1 {
+		t.Fatalf("got out=%x low=%x msb=%v", out, low, msb)
+	}
+}
+
+func TestUserChipsInSameDirectory(t *testing.T) {
+	dir := writeChips(t, map[string]string{
+		// Shadows the builtin Not; the chip must be taken from the directory.
+		"Not": `CHIP Not { IN in; OUT out; PARTS: Nand(a=in, b=false, out=out); }`,
+		"Buf": `CHIP Buf { IN in; OUT out; PARTS: Not(in=in, out=x); Not(in=x, out=out); }`,
+	})
+	l := NewLoader()
+	c, err := l.LoadFile(filepath.Join(dir, "Buf.hdl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Parts[0].Chip.IsBuiltin() {
+		t.Fatal("Not was resolved to the builtin chip")
+	}
+	s := NewSimulator(c)
+	s.Eval()
+	// Nand(in, false) is always 1, so only the shadowing Not gives 1 for in=0.
+	if v, _ := s.Get("out"); v != 1 {
+		t.Fatalf("out = %v, want 1", v)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown chip":    `CHIP T { IN a; OUT out; PARTS: Foo(in=a, out=out); }`,
+		"unknown pin":     `CHIP T { IN a; OUT out; PARTS: Not(x=a, out=out); }`,
+		"out of width":    `CHIP T { IN a[4]; OUT out; PARTS: Not(in=a[4], out=out); }`,
+		"width mismatch":  `CHIP T { IN a[4]; OUT out; PARTS: Not(in=a, out=out); }`,
+		"no source":       `CHIP T { IN a; OUT out; PARTS: Not(in=w, out=out); }`,
+		"driven twice":    `CHIP T { IN a; OUT out; PARTS: Not(in=a, out=w); Not(in=a, out=w); Not(in=w, out=out); }`,
+		"comb loop":       `CHIP T { IN a; OUT out; PARTS: And(a=a, b=w2, out=w1); Not(in=w1, out=w2, out=out); }`,
+		"drive input pin": `CHIP T { IN a; OUT out; PARTS: Not(in=a, out=a); }`,
+	}
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := writeChips(t, map[string]string{"T": src})
+			if _, err := NewLoader().LoadFile(filepath.Join(dir, "T.hdl")); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestLoopThroughDFF(t *testing.T) {
+	c, err := NewLoader().LoadFile("../../3/a/Bit.hdl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSimulator(c)
+	s.Set("in", 1)
+	s.Set("load", 1)
+	s.Tick()
+	s.Tock()
+	s.Set("load", 0)
+	s.Set("in", 0)
+	s.Tick()
+	s.Tock()
+	if v, _ := s.Get("out"); v != 1 {
+		t.Fatalf("out = %v, want 1", v)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	src := `CHIP T {
+    IN a, b[4], c;
+    OUT out, x[2];
+    PARTS:
+    Not(in=b[4], out=w);
+    Not(in=a, out=w);
+    And(a=a, out=out);
+    Not(in=a, out=out);
+}`
+	dir := writeChips(t, map[string]string{"T": src})
+	diags, err := NewLoader().Check(filepath.Join(dir, "T.hdl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		":6: internal pin \"w\" is driven twice",
+		":5: sub bus b[4] is out of width 4",
+		":8: output pin \"out\" is driven twice",
+	}
+	if got := diags.Errors(); len(got) != len(want) {
+		t.Fatalf("got %v errors, want %v:\n%v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if !strings.HasSuffix(diags.Errors()[i].String(), w) {
+			t.Errorf("error %v = %q, want suffix %q", i, diags.Errors()[i], w)
+		}
+	}
+}
+
+func TestCheckWarnings(t *testing.T) {
+	src := `CHIP T {
+    IN a, b[4], c;
+    OUT out, x[2];
+    PARTS:
+    And(a=a, out=w);
+    Not(in=b[0], out=out, out=x[0]);
+}`
+	dir := writeChips(t, map[string]string{"T": src})
+	diags, err := NewLoader().Check(filepath.Join(dir, "T.hdl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`T.hdl:5: warning: input pin "b" of And is not connected`,
+		`T.hdl:2: warning: input pin "b[1..3]" is not used`,
+		`T.hdl:2: warning: input pin "c" is not used`,
+		`T.hdl:3: warning: output pin "x[1]" is not connected`,
+		`T.hdl:5: warning: internal pin "w" is not used`,
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %v diagnostics, want %v:\n%v", len(diags), len(want), diags)
+	}
+	for i, w := range want {
+		if !strings.HasSuffix(diags[i].String(), w) {
+			t.Errorf("diagnostic %v = %q, want suffix %q", i, diags[i], w)
+		}
+	}
+}
+
+func TestCheckLoop(t *testing.T) {
+	src := `CHIP T {
+    IN a;
+    OUT out;
+    PARTS:
+    And(a=a, b=w2, out=w1);
+    Not(in=w1, out=w2, out=out);
+}`
+	dir := writeChips(t, map[string]string{"T": src})
+	_, err := NewLoader().LoadFile(filepath.Join(dir, "T.hdl"))
+	var diags Diagnostics
+	if !errors.As(err, &diags) {
+		t.Fatalf("err = %v, want Diagnostics", err)
+	}
+	want := "T.hdl:5: combinational loop not broken by a DFF: w1 -> w2 -> w1"
+	if len(diags) != 1 || !strings.HasSuffix(diags[0].String(), want) {
+		t.Fatalf("got %v, want %q", diags, want)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	dir := writeChips(t, map[string]string{
+		"MyNot": `CHIP MyNot { IN in; OUT out; PARTS: Nand(a=in, b=in, out=out); }`,
+		"MyAnd": `CHIP MyAnd { IN a, b; OUT out; PARTS: Nand(a=a, b=b, out=n); MyNot(in=n, out=out); }`,
+		"Chain": `CHIP Chain { IN a, b, c; OUT out; PARTS: MyAnd(a=a, b=b, out=x); MyAnd(a=x, b=c, out=out); }`,
+		"Reg":   `CHIP Reg { IN in; OUT out; PARTS: MyAnd(a=in, b=q, out=d); MyNot(in=d, out=e); DFF(in=e, out=q, out=out); }`,
+		"Both":  `CHIP Both { IN in[2]; OUT out; PARTS: Reg(in=in[0], out=r); Chain(a=r, b=in[1], c=r, out=out); Not(in=in[0], out=n); }`,
+	})
+	tests := []struct {
+		chip             string
+		nand, dff, depth int
+		opaque           int
+	}{
+		{"MyNot", 1, 0, 1, 0},
+		{"Chain", 4, 0, 4, 0},
+		{"Reg", 3, 1, 3, 0},
+		{"Both", 7, 1, 4, 1},
+	}
+	l := NewLoader()
+	for _, tt := range tests {
+		c, err := l.LoadFile(filepath.Join(dir, tt.chip+".hdl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := Analyze(c)
+		if r.Nand != tt.nand || r.DFF != tt.dff || r.Depth != tt.depth || len(r.Opaque) != tt.opaque {
+			t.Errorf("%v: got %v Nand, %v DFF, depth %v, opaque %v; want %v, %v, %v, %v",
+				tt.chip, r.Nand, r.DFF, r.Depth, r.Opaque, tt.nand, tt.dff, tt.depth, tt.opaque)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package hdl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Stats is the size of a chip flattened down to Nand and DFF.
+type Stats struct {
+	Nand int
+	DFF  int
+	// Depth is the longest combinational path in Nand gates, from an input
+	// or a DFF output to an output or a DFF input.
+	Depth int
+	// Opaque counts the parts that could not be flattened: builtins other
+	// than Nand and DFF, and chips whose PARTS section is empty. They are
+	// counted as zero gates and zero depth, so the numbers are lower bounds.
+	Opaque map[string]int
+}
+
+func (s *Stats) add(o Stats) {
+	s.Nand += o.Nand
+	s.DFF += o.DFF
+	for name, n := range o.Opaque {
+		if s.Opaque == nil {
+			s.Opaque = make(map[string]int)
+		}
+		s.Opaque[name] += n
+	}
+}
+
+// PartStats is the share of a single part in a Report.
+type PartStats struct {
+	Part *Part
+	Stats
+}
+
+// Report is the gate count and logic depth of a chip and of each of its parts.
+type Report struct {
+	Chip *Chip
+	Stats
+	Parts []PartStats
+}
+
+// Analyze flattens c and counts its gates. Parts used several times are only
+// analysed once.
+func Analyze(c *Chip) *Report {
+	a := &analyzer{summaries: make(map[*Chip]*summary)}
+	r := &Report{Chip: c, Stats: a.summarize(c).Stats}
+	for _, p := range c.Parts {
+		r.Parts = append(r.Parts, PartStats{Part: p, Stats: a.summarize(p.Chip).Stats})
+	}
+	return r
+}
+
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v: %v Nand, %v DFF, depth %v\n", r.Chip.Name, r.Nand, r.DFF, r.Depth)
+	for _, p := range r.Parts {
+		fmt.Fprintf(&sb, "  line %-4v %-12v %8v Nand %8v DFF  depth %v\n", p.Part.Line, p.Part.Chip.Name, p.Nand, p.DFF, p.Depth)
+	}
+	if len(r.Opaque) > 0 {
+		names := make([]string, 0, len(r.Opaque))
+		for name := range r.Opaque {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = fmt.Sprintf("%v x%v", name, r.Opaque[name])
+		}
+		fmt.Fprintf(&sb, "not flattened: %v\n", strings.Join(names, ", "))
+	}
+	return sb.String()
+}
+
+// none marks the absence of a path in a summary.
+const none = -1
+
+// summary describes a chip as seen from the chip using it. Pins are numbered
+// bit by bit, inputs and outputs separately.
+type summary struct {
+	Stats
+	// inOut[i][o] is the longest path from input bit i to output bit o.
+	inOut [][]int
+	// inState[i] is the longest path from input bit i to a DFF inside.
+	inState []int
+	// stateOut[o] is the longest path from a DFF inside to output bit o.
+	stateOut []int
+}
+
+type analyzer struct {
+	summaries map[*Chip]*summary
+}
+
+func bitCount(ps []Pin) int {
+	n := 0
+	for _, p := range ps {
+		n += p.Width
+	}
+	return n
+}
+
+// bitBase returns the number of the first bit of each pin.
+func bitBase(ps []Pin) []int {
+	base := make([]int, len(ps))
+	n := 0
+	for i, p := range ps {
+		base[i] = n
+		n += p.Width
+	}
+	return base
+}
+
+func newSummary(in, out int) *summary {
+	s := &summary{
+		inOut:    make([][]int, in),
+		inState:  make([]int, in),
+		stateOut: make([]int, out),
+	}
+	for i := range s.inOut {
+		s.inOut[i] = make([]int, out)
+		for o := range s.inOut[i] {
+			s.inOut[i][o] = none
+		}
+		s.inState[i] = none
+	}
+	for o := range s.stateOut {
+		s.stateOut[o] = none
+	}
+	return s
+}
+
+func (a *analyzer) summarize(c *Chip) *summary {
+	if s, ok := a.summaries[c]; ok {
+		return s
+	}
+	var s *summary
+	switch {
+	case c.IsBuiltin() || len(c.Parts) == 0:
+		s = opaque(c)
+	default:
+		s = a.composite(c)
+	}
+	a.summaries[c] = s
+	return s
+}
+
+// opaque summarizes a chip that is not made of parts.
+func opaque(c *Chip) *summary {
+	s := newSummary(bitCount(c.Inputs), bitCount(c.Outputs))
+	switch c.Name {
+	case "Nand":
+		s.Nand, s.Depth = 1, 1
+		s.inOut[0][0], s.inOut[1][0] = 1, 1
+		return s
+	case "DFF":
+		s.DFF = 1
+		s.inState[0], s.stateOut[0] = 0, 0
+		return s
+	}
+	s.Opaque = map[string]int{c.Name: 1}
+	// An empty chip behaves like the builtin it stands for, if any.
+	model := c
+	if b, ok := builtins[c.Name]; ok && !c.IsBuiltin() {
+		model = b
+	}
+	inBase, outBase := bitBase(c.Inputs), bitBase(c.Outputs)
+	for i, in := range c.Inputs {
+		for o, out := range c.Outputs {
+			comb := o < len(model.comb) && model.comb[o]&(1<<i) != 0
+			for ib := 0; ib < in.Width; ib++ {
+				for ob := 0; ob < out.Width; ob++ {
+					if comb {
+						s.inOut[inBase[i]+ib][outBase[o]+ob] = 0
+					}
+				}
+			}
+		}
+		if model.Stateful && !model.isCombInput(i) {
+			for ib := 0; ib < in.Width; ib++ {
+				s.inState[inBase[i]+ib] = 0
+			}
+		}
+	}
+	if model.Stateful {
+		for o := range s.stateOut {
+			s.stateOut[o] = 0
+		}
+	}
+	return s
+}
+
+// composite summarizes a chip made of parts by finding the longest paths
+// between the bits of its signals.
+func (a *analyzer) composite(c *Chip) *summary {
+	nIn, nOut := bitCount(c.Inputs), bitCount(c.Outputs)
+	s := newSummary(nIn, nOut)
+	s.Depth = none
+
+	// Signal bits are numbered like the signals; the constants have no bits.
+	sigs := append(append(append([]Pin{}, c.Inputs...), c.Outputs...), c.Wires...)
+	sigBase := bitBase(sigs)
+	nBits := bitCount(sigs)
+
+	// dist[b][src] is the longest path to signal bit b from input bit src,
+	// or from any DFF when src is nIn.
+	state := nIn
+	dist := make([][]int, nBits)
+	for b := range dist {
+		dist[b] = make([]int, nIn+1)
+		for src := range dist[b] {
+			dist[b][src] = none
+		}
+	}
+	for b := 0; b < nIn; b++ {
+		dist[b][b] = 0
+	}
+
+	type edge struct{ from, to, w int }
+	var edges []edge
+	sources := make([][]int, nBits) // DFF to bit paths
+	parts := make([]*summary, len(c.Parts))
+	for pi, p := range c.Parts {
+		ps := a.summarize(p.Chip)
+		parts[pi] = ps
+		s.add(ps.Stats)
+		if ps.Depth > s.Depth {
+			s.Depth = ps.Depth
+		}
+		inBase, outBase := bitBase(p.Chip.Inputs), bitBase(p.Chip.Outputs)
+		for _, in := range p.Conns {
+			if !in.In || in.Sig >= c.sigFalse() {
+				continue
+			}
+			for _, out := range p.Conns {
+				if out.In || out.Sig >= c.sigFalse() {
+					continue
+				}
+				o := out.Pin - len(p.Chip.Inputs)
+				for ib := 0; ib < in.Width; ib++ {
+					for ob := 0; ob < out.Width; ob++ {
+						w := ps.inOut[inBase[in.Pin]+in.PinLo+ib][outBase[o]+out.PinLo+ob]
+						if w != none {
+							edges = append(edges, edge{sigBase[in.Sig] + in.SigLo + ib, sigBase[out.Sig] + out.SigLo + ob, w})
+						}
+					}
+				}
+			}
+		}
+		for _, out := range p.Conns {
+			if out.In || out.Sig >= c.sigFalse() {
+				continue
+			}
+			o := out.Pin - len(p.Chip.Inputs)
+			for ob := 0; ob < out.Width; ob++ {
+				if w := ps.stateOut[outBase[o]+out.PinLo+ob]; w != none {
+					b := sigBase[out.Sig] + out.SigLo + ob
+					sources[b] = append(sources[b], w)
+				}
+			}
+		}
+	}
+
+	// The bit graph has no cycle, as combinational loops are rejected when
+	// the chip is built, so relaxing the edges in topological order is enough.
+	next := make([][]edge, nBits)
+	for _, e := range edges {
+		next[e.from] = append(next[e.from], e)
+	}
+	for _, b := range bitOrder(next, func(e edge) int { return e.to }) {
+		for _, w := range sources[b] {
+			dist[b][state] = max(dist[b][state], w)
+		}
+		for _, e := range next[b] {
+			for src, d := range dist[b] {
+				if d != none {
+					dist[e.to][src] = max(dist[e.to][src], d+e.w)
+				}
+			}
+		}
+	}
+
+	outSig := nIn
+	for o := 0; o < nOut; o++ {
+		for i := 0; i < nIn; i++ {
+			s.inOut[i][o] = dist[outSig+o][i]
+		}
+		s.stateOut[o] = dist[outSig+o][state]
+	}
+	for pi, p := range c.Parts {
+		inBase := bitBase(p.Chip.Inputs)
+		for _, in := range p.Conns {
+			if !in.In || in.Sig >= c.sigFalse() {
+				continue
+			}
+			for ib := 0; ib < in.Width; ib++ {
+				w := parts[pi].inState[inBase[in.Pin]+in.PinLo+ib]
+				if w == none {
+					continue
+				}
+				for src, d := range dist[sigBase[in.Sig]+in.SigLo+ib] {
+					if d == none {
+						continue
+					}
+					if src == state {
+						s.Depth = max(s.Depth, d+w)
+					} else {
+						s.inState[src] = max(s.inState[src], d+w)
+					}
+				}
+			}
+		}
+	}
+
+	// The depth seen from outside includes the paths through the chip pins.
+	for i := range s.inOut {
+		for _, d := range s.inOut[i] {
+			s.Depth = max(s.Depth, d)
+		}
+		s.Depth = max(s.Depth, s.inState[i])
+	}
+	for _, d := range s.stateOut {
+		s.Depth = max(s.Depth, d)
+	}
+	s.Depth = max(s.Depth, 0)
+	return s
+}
+
+// bitOrder is a topological order of the nodes of a graph given as the edges
+// leaving each node.
+func bitOrder[E any](next [][]E, to func(E) int) []int {
+	n := len(next)
+	indeg := make([]int, n)
+	for _, es := range next {
+		for _, e := range es {
+			indeg[to(e)]++
+		}
+	}
+	order := make([]int, 0, n)
+	for b := 0; b < n; b++ {
+		if indeg[b] == 0 {
+			order = append(order, b)
+		}
+	}
+	for i := 0; i < len(order); i++ {
+		for _, e := range next[order[i]] {
+			if t := to(e); indeg[t] == 1 {
+				order = append(order, t)
+			}
+			indeg[to(e)]--
+		}
+	}
+	return order
+}
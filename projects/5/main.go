@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nand2tetris-5/emulator"
+	"nand2tetris-5/hdl"
+)
+
+func main() {
+	src := flag.String("src", "", "source file path (.hack, .tst, .hdl)")
+	stats := flag.Bool("stats", false, "report gate count and logic depth of an .hdl chip")
+	lib := flag.String("lib", "", "directories searched for the parts of an .hdl chip, separated by the OS path list separator")
+	cycles := flag.Uint64("cycles", 100_000_000, "max number of instructions to execute")
+	flag.Parse()
+
+	if src == nil || *src == "" {
+		log.Fatal("not set source path")
+	}
+
+	switch filepath.Ext(*src) {
+	case ".hack":
+		if err := runHack(*src, *cycles); err != nil {
+			log.Fatalf("%v\n", err)
+		}
+	case ".tst":
+		if err := hdl.RunFile(*src); err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		fmt.Println("End of script - Comparison ended successfully")
+	case ".hdl":
+		if err := checkHDL(*src, filepath.SplitList(*lib), *stats); err != nil {
+			log.Fatalf("%v\n", err)
+		}
+	default:
+		log.Fatalf("unsupported file %q\n", *src)
+	}
+}
+
+func runHack(path string, cycles uint64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m := emulator.New()
+	if err := m.LoadHack(f); err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	start := time.Now()
+	m.Run(cycles)
+	elapsed := time.Since(start)
+
+	fmt.Printf("executed %v instructions in %v (%.1f M instr/s), halted: %v\n",
+		m.Cycles, elapsed, float64(m.Cycles)/elapsed.Seconds()/1e6, m.Halted)
+	var sb strings.Builder
+	for i := 0; i < 16; i++ {
+		sb.WriteString(fmt.Sprintf("R%v=%v ", i, m.RAM[i]))
+	}
+	fmt.Println(strings.TrimSpace(sb.String()))
+	return nil
+}
+
+// checkHDL reports the problems of a chip without simulating it, then its
+// gate count if stats is set.
+func checkHDL(path string, lib []string, stats bool) error {
+	l := hdl.NewLoader(lib...)
+	diags, err := l.Check(path)
+	if err != nil {
+		return err
+	}
+	for _, d := range diags {
+		fmt.Println(d)
+	}
+	if errs := diags.Errors(); len(errs) > 0 {
+		return fmt.Errorf("%v: %v errors", path, len(errs))
+	}
+	fmt.Printf("%v: %v warnings\n", path, len(diags))
+	if !stats {
+		return nil
+	}
+	c, err := l.LoadFile(path)
+	if err != nil {
+		return err
+	}
+	fmt.Print(hdl.Analyze(c))
+	return nil
+}
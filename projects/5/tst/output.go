@@ -0,0 +1,150 @@
+package tst
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// column is an entry of an output-list, like "out%B1.16.1".
+type column struct {
+	name string
+	// format is one of 'B', 'D', 'X' and 'S'.
+	format     byte
+	padL, padR int
+	length     int
+}
+
+// parseColumn parses "name" or "name%Fl.n.r".
+func parseColumn(s string, width int) (column, error) {
+	name, spec, found := strings.Cut(s, "%")
+	col := column{name: name, format: 'B', padL: 1, length: width, padR: 1}
+	if name == "time" {
+		col.format, col.length = 'S', 4
+	}
+	if !found {
+		return col, nil
+	}
+	if len(spec) == 0 {
+		return column{}, fmt.Errorf("invalid output format %q", s)
+	}
+	col.format = spec[0]
+	if !strings.ContainsRune("BDXS", rune(col.format)) {
+		return column{}, fmt.Errorf("invalid output format %q", s)
+	}
+	parts := strings.Split(spec[1:], ".")
+	if len(parts) != 3 {
+		return column{}, fmt.Errorf("invalid output format %q", s)
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return column{}, fmt.Errorf("invalid output format %q", s)
+		}
+		nums[i] = n
+	}
+	col.padL, col.length, col.padR = nums[0], nums[1], nums[2]
+	return col, nil
+}
+
+func (c column) width() int {
+	return c.padL + c.length + c.padR
+}
+
+// header centers the name in the column, cutting it if it does not fit.
+func (c column) header() string {
+	w := c.width()
+	name := c.name
+	if len(name) > w {
+		name = name[:w]
+	}
+	left := (w - len(name)) / 2
+	return strings.Repeat(" ", left) + name + strings.Repeat(" ", w-left-len(name))
+}
+
+// formatValue formats v, a pin of the given width, according to the column.
+func (c column) formatValue(v uint16, width int) string {
+	var s string
+	switch c.format {
+	case 'B':
+		s = strconv.FormatUint(uint64(v), 2)
+		s = padLeft(s, c.length, '0')
+		s = s[len(s)-c.length:]
+	case 'X':
+		s = strings.ToUpper(strconv.FormatUint(uint64(v), 16))
+		s = padLeft(s, c.length, '0')
+		s = s[len(s)-c.length:]
+	case 'D':
+		if width == 16 {
+			s = strconv.Itoa(int(int16(v)))
+		} else {
+			s = strconv.Itoa(int(v))
+		}
+		s = padLeft(s, c.length, ' ')
+	case 'S':
+		s = strconv.Itoa(int(v))
+		s = padRight(s, c.length)
+	}
+	return c.pad(s)
+}
+
+func (c column) formatString(s string) string {
+	return c.pad(padRight(s, c.length))
+}
+
+func (c column) pad(s string) string {
+	return strings.Repeat(" ", c.padL) + s + strings.Repeat(" ", c.padR)
+}
+
+func padLeft(s string, n int, r byte) string {
+	if len(s) >= n {
+		return s
+	}
+	return strings.Repeat(string(r), n-len(s)) + s
+}
+
+func padRight(s string, n int) string {
+	if len(s) >= n {
+		return s
+	}
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+// parseValue parses a value of a set command or a while condition:
+// a decimal number or %B, %X, %D prefixed literals.
+func parseValue(s string) (uint16, error) {
+	base := 10
+	digits := s
+	if strings.HasPrefix(s, "%") && len(s) > 1 {
+		switch s[1] {
+		case 'B':
+			base = 2
+		case 'X':
+			base = 16
+		case 'D':
+			base = 10
+		default:
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		digits = s[2:]
+	}
+	n, err := strconv.ParseInt(digits, base, 32)
+	if err != nil || n < -32768 || n > 65535 {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return uint16(n), nil
+}
+
+// matchLine compares an output line with a .cmp line where '*' matches any character.
+func matchLine(got, want string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := 0; i < len(want); i++ {
+		if want[i] != '*' && want[i] != got[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,312 @@
+package tst
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Machine is what a script drives: a chip, a CPU or a VM. The runner handles
+// the commands common to every simulator and leaves the others to Exec.
+type Machine interface {
+	// Exec runs a command of the simulator, like load, tick or vmstep, and
+	// reports false if it does not know the command.
+	Exec(cmd Command) (bool, error)
+	Get(name string) (uint16, error)
+	Set(name string, v uint16) error
+	// Width is the width of the variable in bits, 16 if it is unknown.
+	Width(name string) int
+}
+
+// Formatter is implemented by machines with variables that are not numbers,
+// like the "time" of the HardwareSimulator.
+type Formatter interface {
+	Format(name string) (string, bool)
+}
+
+// CompareError reports the first output line that does not match the .cmp file.
+type CompareError struct {
+	Line      int
+	Got, Want string
+}
+
+func (e *CompareError) Error() string {
+	return fmt.Sprintf("comparison failure at line %v:\n  want: %v\n  got:  %v", e.Line, e.Want, e.Got)
+}
+
+// Runner executes test scripts.
+type Runner struct {
+	Machine Machine
+	// Dir is the directory output-file and compare-to are relative to.
+	Dir string
+	// OutDir is where output-file is written. It defaults to Dir.
+	OutDir string
+	// Echo receives the messages of echo commands, and "" for clear-echo.
+	Echo func(msg string)
+
+	columns []column
+	out     *bufio.Writer
+	outFile *os.File
+	cmp     []string
+	outLine int
+}
+
+func NewRunner(m Machine, dir string) *Runner {
+	return &Runner{Machine: m, Dir: dir}
+}
+
+// RunFile parses and runs the .tst file at path.
+func (r *Runner) RunFile(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	s, err := Parse(string(src))
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	if err := r.Run(s); err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	return nil
+}
+
+// Run executes the script. The output file is closed when Run returns. The
+// run fails if the .cmp file has lines left that the script did not output.
+func (r *Runner) Run(s *Script) error {
+	defer r.closeOutput()
+	if err := r.exec(s.commands); err != nil {
+		return err
+	}
+	for i := r.outLine; i < len(r.cmp); i++ {
+		if r.cmp[i] != "" {
+			return &CompareError{Line: i + 1, Want: r.cmp[i]}
+		}
+	}
+	return nil
+}
+
+// scriptError is an error positioned at a line of the script.
+type scriptError struct {
+	line int
+	err  error
+}
+
+func (e *scriptError) Error() string {
+	return fmt.Sprintf("line %v: %v", e.line, e.err)
+}
+
+func (e *scriptError) Unwrap() error {
+	return e.err
+}
+
+func (r *Runner) exec(commands []Command) error {
+	for _, cmd := range commands {
+		if err := r.execOne(cmd); err != nil {
+			var se *scriptError
+			var ce *CompareError
+			if errors.As(err, &se) || errors.As(err, &ce) {
+				return err
+			}
+			return &scriptError{line: cmd.Line, err: err}
+		}
+	}
+	return nil
+}
+
+func (r *Runner) execOne(cmd Command) error {
+	switch cmd.Name {
+	case "output-file":
+		if len(cmd.Args) != 1 {
+			return fmt.Errorf("output-file expects a file name")
+		}
+		r.closeOutput()
+		dir := r.OutDir
+		if dir == "" {
+			dir = r.Dir
+		}
+		f, err := os.Create(filepath.Join(dir, cmd.Args[0]))
+		if err != nil {
+			return err
+		}
+		r.outFile, r.out = f, bufio.NewWriter(f)
+		r.outLine = 0
+	case "compare-to":
+		if len(cmd.Args) != 1 {
+			return fmt.Errorf("compare-to expects a file name")
+		}
+		src, err := os.ReadFile(filepath.Join(r.Dir, cmd.Args[0]))
+		if err != nil {
+			return err
+		}
+		r.cmp = strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n")
+	case "output-list":
+		r.columns = nil
+		for _, a := range cmd.Args {
+			name, _, _ := strings.Cut(a, "%")
+			col, err := parseColumn(a, r.Machine.Width(name))
+			if err != nil {
+				return err
+			}
+			r.columns = append(r.columns, col)
+		}
+		var sb strings.Builder
+		sb.WriteString("|")
+		for _, col := range r.columns {
+			sb.WriteString(col.header() + "|")
+		}
+		return r.writeLine(sb.String())
+	case "set":
+		if len(cmd.Args) != 2 {
+			return fmt.Errorf("set expects a variable and a value")
+		}
+		v, err := parseValue(cmd.Args[1])
+		if err != nil {
+			return err
+		}
+		return r.Machine.Set(cmd.Args[0], v)
+	case "output":
+		var sb strings.Builder
+		sb.WriteString("|")
+		for _, col := range r.columns {
+			s, err := r.format(col)
+			if err != nil {
+				return err
+			}
+			sb.WriteString(s + "|")
+		}
+		return r.writeLine(sb.String())
+	case "echo":
+		if r.Echo != nil {
+			r.Echo(strings.Join(cmd.Args, " "))
+		}
+	case "clear-echo":
+		if r.Echo != nil {
+			r.Echo("")
+		}
+	case "breakpoint", "clear-breakpoints":
+		// breakpoints only matter to the interactive simulators
+	case "repeat":
+		if cmd.count < 0 {
+			return fmt.Errorf("repeat without a count never ends")
+		}
+		for i := 0; i < cmd.count; i++ {
+			if err := r.exec(cmd.body); err != nil {
+				return err
+			}
+		}
+	case "while":
+		for {
+			ok, err := r.cond(cmd.cond)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if err := r.exec(cmd.body); err != nil {
+				return err
+			}
+		}
+	default:
+		ok, err := r.Machine.Exec(cmd)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("unknown command %q", cmd.Name)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) closeOutput() {
+	if r.out != nil {
+		r.out.Flush()
+		r.outFile.Close()
+		r.out, r.outFile = nil, nil
+	}
+}
+
+// writeLine writes a line of the output file and compares it with the .cmp file.
+func (r *Runner) writeLine(s string) error {
+	r.outLine++
+	if r.out != nil {
+		if _, err := r.out.WriteString(s + "\n"); err != nil {
+			return err
+		}
+	}
+	if r.cmp == nil {
+		return nil
+	}
+	want := ""
+	if r.outLine <= len(r.cmp) {
+		want = r.cmp[r.outLine-1]
+	}
+	if !matchLine(s, want) {
+		return &CompareError{Line: r.outLine, Got: s, Want: want}
+	}
+	return nil
+}
+
+// SplitVar splits "RAM16K[3]" into "RAM16K" and 3, and "DRegister[]" into
+// "DRegister" and -1. indexed is false for plain names.
+func SplitVar(name string) (base string, index int, indexed bool, err error) {
+	open := strings.IndexByte(name, '[')
+	if open < 0 || !strings.HasSuffix(name, "]") {
+		return name, 0, false, nil
+	}
+	base, idx := name[:open], name[open+1:len(name)-1]
+	if idx == "" {
+		return base, -1, true, nil
+	}
+	index, err = strconv.Atoi(idx)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("invalid variable %q", name)
+	}
+	return base, index, true, nil
+}
+
+func (r *Runner) format(col column) (string, error) {
+	if f, ok := r.Machine.(Formatter); ok {
+		if s, ok := f.Format(col.name); ok {
+			return col.formatString(s), nil
+		}
+	}
+	v, err := r.Machine.Get(col.name)
+	if err != nil {
+		return "", err
+	}
+	return col.formatValue(v, r.Machine.Width(col.name)), nil
+}
+
+func (r *Runner) cond(c []string) (bool, error) {
+	v, err := r.Machine.Get(c[0])
+	if err != nil {
+		return false, err
+	}
+	want, err := parseValue(c[2])
+	if err != nil {
+		return false, err
+	}
+	x, y := int16(v), int16(want)
+	switch c[1] {
+	case "=":
+		return x == y, nil
+	case "<>":
+		return x != y, nil
+	case "<":
+		return x < y, nil
+	case ">":
+		return x > y, nil
+	case "<=":
+		return x <= y, nil
+	case ">=":
+		return x >= y, nil
+	}
+	return false, fmt.Errorf("invalid operator %q", c[1])
+}
@@ -0,0 +1,167 @@
+package tst
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// counter is a machine with 16-bit variables and a tick command
+// incrementing n.
+type counter struct {
+	vars map[string]uint16
+}
+
+func (c *counter) Exec(cmd Command) (bool, error) {
+	if cmd.Name != "tick" {
+		return false, nil
+	}
+	c.vars["n"]++
+	return true, nil
+}
+
+func (c *counter) Get(name string) (uint16, error) {
+	v, ok := c.vars[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown variable %q", name)
+	}
+	return v, nil
+}
+
+func (c *counter) Set(name string, v uint16) error {
+	c.vars[name] = v
+	return nil
+}
+
+func (c *counter) Width(name string) int {
+	return 16
+}
+
+const counterScript = `// A counter.
+output-file T.out,
+compare-to T.cmp,
+output-list n%D1.3.1 a%B1.4.1 a%X1.2.1;
+
+set a %B101, set n 0,
+output;
+repeat 2 {
+    tick, output;
+}
+while n < 5 {
+    tick;
+}
+output;
+`
+
+var counterOutput = []string{
+	"|  n  |  a   | a  |",
+	"|   0 | 0101 | 05 |",
+	"|   1 | 0101 | 05 |",
+	"|   2 | 0101 | 05 |",
+	"|   5 | 0101 | 05 |",
+}
+
+// runCounter runs counterScript with cmp as the .cmp file, and returns the
+// lines of the .out file and the error of the run.
+func runCounter(t *testing.T, cmp []string) ([]string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range map[string]string{"T.tst": counterScript, "T.cmp": strings.Join(cmp, "\n") + "\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewRunner(&counter{vars: map[string]uint16{}}, dir)
+	err := r.RunFile(filepath.Join(dir, "T.tst"))
+	out, rerr := os.ReadFile(filepath.Join(dir, "T.out"))
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"), err
+}
+
+func TestRun(t *testing.T) {
+	// '*' matches any character.
+	cmp := append([]string{}, counterOutput...)
+	cmp[2] = "|   * | 0101 | ** |"
+	out, err := runCounter(t, cmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(out, "\n") != strings.Join(counterOutput, "\n") {
+		t.Errorf("got\n%v\nwant\n%v", strings.Join(out, "\n"), strings.Join(counterOutput, "\n"))
+	}
+}
+
+func TestCompareFailure(t *testing.T) {
+	cmp := append([]string{}, counterOutput...)
+	cmp[3] = "|   3 | 0101 | 05 |"
+	out, err := runCounter(t, cmp)
+	var ce *CompareError
+	if !errors.As(err, &ce) {
+		t.Fatalf("got %v, want a comparison failure", err)
+	}
+	if ce.Line != 4 || ce.Got != counterOutput[3] || ce.Want != cmp[3] {
+		t.Errorf("got %+v", ce)
+	}
+	// The output stops at the failing line.
+	if len(out) != 4 {
+		t.Errorf("got %v output lines, want 4", len(out))
+	}
+}
+
+func TestMissingOutput(t *testing.T) {
+	cmp := append(append([]string{}, counterOutput...), "|   6 | 0101 | 05 |")
+	out, err := runCounter(t, cmp)
+	var ce *CompareError
+	if !errors.As(err, &ce) {
+		t.Fatalf("got %v, want a comparison failure", err)
+	}
+	if ce.Line != 6 || ce.Got != "" || ce.Want != cmp[5] {
+		t.Errorf("got %+v", ce)
+	}
+	if len(out) != 5 {
+		t.Errorf("got %v output lines, want 5", len(out))
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		"repeat 2 tick;",
+		"repeat x { tick; }",
+		"while n < { tick; }",
+		"repeat { tick;",
+		"tick; }",
+		"/* unterminated",
+		`echo "unterminated`,
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("no error parsing %q", src)
+		}
+	}
+}
+
+func TestSplitVar(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		index   int
+		indexed bool
+	}{
+		{"out", "out", 0, false},
+		{"RAM16K[3]", "RAM16K", 3, true},
+		{"DRegister[]", "DRegister", -1, true},
+	}
+	for _, tt := range tests {
+		base, index, indexed, err := SplitVar(tt.name)
+		if err != nil || base != tt.base || index != tt.index || indexed != tt.indexed {
+			t.Errorf("SplitVar(%q) = %q, %v, %v, %v", tt.name, base, index, indexed, err)
+		}
+	}
+	if _, _, _, err := SplitVar("RAM[x]"); err == nil {
+		t.Error("no error for RAM[x]")
+	}
+}
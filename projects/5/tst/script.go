@@ -0,0 +1,165 @@
+// Package tst runs the test scripts (.tst) of the nand2tetris simulators and
+// compares their output with .cmp files.
+package tst
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Command is a single command of a test script. repeat and while carry a body.
+type Command struct {
+	Name string
+	Args []string
+	Line int
+
+	count int      // repeat count
+	cond  []string // while condition: variable, operator, value
+	body  []Command
+}
+
+// Script is a parsed test script (.tst).
+type Script struct {
+	commands []Command
+}
+
+type scriptToken struct {
+	value string
+	line  int
+	// quoted is set for "..." strings, which may contain separators.
+	quoted bool
+}
+
+func (t scriptToken) isSep() bool {
+	return !t.quoted && (t.value == "," || t.value == ";" || t.value == "!")
+}
+
+func tokenizeScript(src string) ([]scriptToken, error) {
+	var tokens []scriptToken
+	line := 1
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, scriptToken{value: word.String(), line: line})
+			word.Reset()
+		}
+	}
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			flush()
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			flush()
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %v: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 3
+		case c == '"':
+			flush()
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("line %v: unterminated string", line)
+			}
+			tokens = append(tokens, scriptToken{value: src[i+1 : i+1+end], line: line, quoted: true})
+			i += end + 1
+		case c == ',' || c == ';' || c == '!' || c == '{' || c == '}':
+			flush()
+			tokens = append(tokens, scriptToken{value: string(c), line: line})
+		case c == '\n':
+			flush()
+			line++
+		case c == ' ' || c == '\t' || c == '\r':
+			flush()
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens, nil
+}
+
+// Parse parses the source of a .tst file.
+func Parse(src string) (*Script, error) {
+	tokens, err := tokenizeScript(src)
+	if err != nil {
+		return nil, err
+	}
+	pos := 0
+	commands, err := parseCommands(tokens, &pos, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{commands: commands}, nil
+}
+
+func parseCommands(tokens []scriptToken, pos *int, inBlock bool) ([]Command, error) {
+	var commands []Command
+	for *pos < len(tokens) {
+		t := tokens[*pos]
+		if t.isSep() {
+			*pos++
+			continue
+		}
+		if !t.quoted && t.value == "}" {
+			if !inBlock {
+				return nil, fmt.Errorf("line %v: unexpected \"}\"", t.line)
+			}
+			*pos++
+			return commands, nil
+		}
+
+		cmd := Command{Name: t.value, Line: t.line}
+		*pos++
+		for *pos < len(tokens) {
+			a := tokens[*pos]
+			if a.isSep() || (!a.quoted && (a.value == "{" || a.value == "}")) {
+				break
+			}
+			cmd.Args = append(cmd.Args, a.value)
+			*pos++
+		}
+
+		switch cmd.Name {
+		case "repeat", "while":
+			if *pos >= len(tokens) || tokens[*pos].value != "{" {
+				return nil, fmt.Errorf("line %v: expected \"{\" after %v", t.line, cmd.Name)
+			}
+			*pos++
+			if cmd.Name == "repeat" {
+				cmd.count = -1
+				if len(cmd.Args) == 1 {
+					n, err := strconv.Atoi(cmd.Args[0])
+					if err != nil || n < 0 {
+						return nil, fmt.Errorf("line %v: invalid repeat count %q", t.line, cmd.Args[0])
+					}
+					cmd.count = n
+				} else if len(cmd.Args) > 1 {
+					return nil, fmt.Errorf("line %v: invalid repeat %q", t.line, cmd.Args)
+				}
+			} else {
+				if len(cmd.Args) != 3 {
+					return nil, fmt.Errorf("line %v: invalid while condition %q", t.line, cmd.Args)
+				}
+				cmd.cond = cmd.Args
+			}
+			body, err := parseCommands(tokens, pos, true)
+			if err != nil {
+				return nil, err
+			}
+			cmd.body = body
+		}
+		commands = append(commands, cmd)
+	}
+	if inBlock {
+		return nil, fmt.Errorf("missing \"}\"")
+	}
+	return commands, nil
+}
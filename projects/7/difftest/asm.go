@@ -0,0 +1,122 @@
+package difftest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// comment is a "// ..." line of the assembly and the ROM address of the
+// instruction that follows it.
+type comment struct {
+	text string
+	addr int
+}
+
+var predefined = map[string]int{
+	"SP": 0, "LCL": 1, "ARG": 2, "THIS": 3, "THAT": 4,
+	"R0": 0, "R1": 1, "R2": 2, "R3": 3, "R4": 4, "R5": 5, "R6": 6, "R7": 7,
+	"R8": 8, "R9": 9, "R10": 10, "R11": 11, "R12": 12, "R13": 13, "R14": 14, "R15": 15,
+	"SCREEN": 0x4000, "KBD": 0x6000,
+}
+
+var destBits = map[string]uint16{
+	"": 0, "M": 1, "D": 2, "MD": 3, "DM": 3, "A": 4, "AM": 5, "MA": 5, "AD": 6, "DA": 6, "AMD": 7, "ADM": 7,
+}
+
+var compBits = map[string]uint16{
+	"0": 0b0101010, "1": 0b0111111, "-1": 0b0111010,
+	"D": 0b0001100, "A": 0b0110000, "!D": 0b0001101, "!A": 0b0110001,
+	"-D": 0b0001111, "-A": 0b0110011, "D+1": 0b0011111, "A+1": 0b0110111,
+	"D-1": 0b0001110, "A-1": 0b0110010, "D+A": 0b0000010, "A+D": 0b0000010,
+	"D-A": 0b0010011, "A-D": 0b0000111, "D&A": 0b0000000, "A&D": 0b0000000,
+	"D|A": 0b0010101, "A|D": 0b0010101,
+	"M": 0b1110000, "!M": 0b1110001, "-M": 0b1110011, "M+1": 0b1110111,
+	"M-1": 0b1110010, "D+M": 0b1000010, "M+D": 0b1000010, "D-M": 0b1010011,
+	"M-D": 0b1000111, "D&M": 0b1000000, "M&D": 0b1000000, "D|M": 0b1010101, "M|D": 0b1010101,
+}
+
+var jumpBits = map[string]uint16{
+	"": 0, "JGT": 1, "JEQ": 2, "JGE": 3, "JLT": 4, "JNE": 5, "JLE": 6, "JMP": 7,
+}
+
+// assemble translates Hack assembly into machine code. Variables are
+// allocated from RAM[16] in the order they first appear, like the assembler
+// of project 6 does.
+func assemble(src []byte) ([]uint16, []comment, error) {
+	type line struct {
+		no   int
+		text string
+	}
+	var lines []line
+	var comments []comment
+	symbols := map[string]int{}
+	for k, v := range predefined {
+		symbols[k] = v
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	no := 0
+	for scanner.Scan() {
+		no++
+		text, c, hasComment := strings.Cut(scanner.Text(), "//")
+		text = strings.ReplaceAll(strings.TrimSpace(text), " ", "")
+		if hasComment && text == "" {
+			comments = append(comments, comment{text: strings.TrimSpace(c), addr: len(lines)})
+		}
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "("):
+			if !strings.HasSuffix(text, ")") || len(text) < 3 {
+				return nil, nil, fmt.Errorf("line %v: invalid label %q", no, text)
+			}
+			label := text[1 : len(text)-1]
+			if _, ok := symbols[label]; ok {
+				return nil, nil, fmt.Errorf("line %v: symbol %v is defined twice", no, label)
+			}
+			symbols[label] = len(lines)
+		default:
+			lines = append(lines, line{no, text})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	program := make([]uint16, len(lines))
+	nextVar := 16
+	for i, l := range lines {
+		if strings.HasPrefix(l.text, "@") {
+			s := l.text[1:]
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				var ok bool
+				if v, ok = symbols[s]; !ok {
+					v = nextVar
+					symbols[s] = v
+					nextVar++
+				}
+			}
+			if v < 0 || v > 32767 {
+				return nil, nil, fmt.Errorf("line %v: %v is out of range", l.no, s)
+			}
+			program[i] = uint16(v)
+			continue
+		}
+		dest, rest, ok := strings.Cut(l.text, "=")
+		if !ok {
+			dest, rest = "", l.text
+		}
+		comp, jump, _ := strings.Cut(rest, ";")
+		d, okDest := destBits[dest]
+		c, okComp := compBits[comp]
+		j, okJump := jumpBits[jump]
+		if !okDest || !okComp || !okJump {
+			return nil, nil, fmt.Errorf("line %v: invalid instruction %q", l.no, l.text)
+		}
+		program[i] = 0b111<<13 | c<<6 | d<<3 | j
+	}
+	return program, comments, nil
+}
@@ -0,0 +1,316 @@
+// Package difftest runs a VM program both in the VM interpreter and,
+// translated to Hack, in the CPU emulator, to find the first VM command whose
+// translation does not do what the command does.
+package difftest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"nand2tetris-5/emulator"
+	"nand2tetris-7/translator"
+	"nand2tetris-7/vm"
+)
+
+const (
+	defaultSteps = 1_000_000
+	// maxInstructions bounds the Hack instructions of a single VM command.
+	maxInstructions = 10_000
+
+	stackBase = 256
+	heapBase  = 2048
+)
+
+// Options configure a run.
+type Options struct {
+	// Translator translates the program for the CPU emulator.
+	Translator translator.Translator
+	// RAM is set in both machines before running, like the set commands of
+	// the test scripts of project 7.
+	RAM map[int]int16
+	// Steps is the maximum number of VM commands to execute, 1,000,000 if 0.
+	Steps uint64
+}
+
+// Diff is a RAM word that differs between the two machines.
+type Diff struct {
+	Addr     int
+	VM, Hack int16
+}
+
+// Divergence is the first VM command after which the two machines disagree.
+type Divergence struct {
+	// File is empty when the bootstrap code went wrong.
+	File     string
+	Line     int
+	Function string
+	Command  translator.Command
+	// Step is the number of VM commands executed, this one included.
+	Step uint64
+	// Diffs are the RAM words that differ. Return addresses on the stack are
+	// shown as ROM addresses in both machines.
+	Diffs []Diff
+	// Msg tells where the Hack code went when it did not continue at the
+	// code of the next VM command.
+	Msg string
+}
+
+func (d *Divergence) Error() string {
+	var sb strings.Builder
+	if d.File == "" {
+		sb.WriteString("bootstrap: ")
+	} else {
+		sb.WriteString(fmt.Sprintf("%v:%v: %q (step %v", d.File, d.Line, d.Command.String(), d.Step))
+		if d.Function != "" {
+			sb.WriteString(", function " + d.Function)
+		}
+		sb.WriteString("): ")
+	}
+	if d.Msg != "" {
+		sb.WriteString(d.Msg)
+		return sb.String()
+	}
+	const maxDiffs = 8
+	for i, diff := range d.Diffs {
+		if i == maxDiffs {
+			sb.WriteString(fmt.Sprintf(", and %v more", len(d.Diffs)-maxDiffs))
+			break
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(fmt.Sprintf("RAM[%v] is %v in the VM but %v in Hack", diff.Addr, diff.VM, diff.Hack))
+	}
+	return sb.String()
+}
+
+// Run executes files in both machines in lock step, comparing their RAM at
+// every return and when the program halts. When they differ, the run is
+// repeated comparing after every command to find the first command whose
+// translation went wrong. Run returns nil when there was no difference in
+// opts.Steps commands.
+func Run(files []translator.File, opts Options) (*Divergence, error) {
+	if opts.Steps == 0 {
+		opts.Steps = defaultSteps
+	}
+	s, err := newSession(files, opts)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.run(false, opts.Steps)
+	if d == nil || err != nil {
+		return d, err
+	}
+	first, err := s.run(true, d.Step)
+	if first == nil && err == nil {
+		return d, nil
+	}
+	return first, err
+}
+
+type session struct {
+	files   []translator.File
+	opts    Options
+	program []uint16
+	// addrs are the ROM addresses of the code of each VM command, followed
+	// by the address the code continues at after the last command.
+	addrs []int
+	// starts tells whether the code of a command starts at a ROM address.
+	starts    []bool
+	bootstrap bool
+}
+
+// newSession translates and assembles files. The code of each command is
+// found from the comments the CodeWriter writes before it.
+func newSession(files []translator.File, opts Options) (*session, error) {
+	if tr := opts.Translator; tr.Optimize || tr.InlineSize > 0 || tr.TailCalls || tr.PruneFunctions {
+		return nil, fmt.Errorf("the optimized code cannot be compared command by command")
+	}
+	out, diags := opts.Translator.Translate(files)
+	if len(diags) > 0 {
+		return nil, diagErrors(diags)
+	}
+	program, comments, err := assemble(out)
+	if err != nil {
+		return nil, fmt.Errorf("assembling the translated code: %w", err)
+	}
+	var cmds []translator.Command
+	for _, f := range files {
+		c, _ := translator.Parse(f)
+		cmds = append(cmds, c...)
+	}
+
+	markers := map[string]bool{}
+	for _, f := range files {
+		markers["---"+f.Name+"---"] = true
+	}
+	s := &session{files: files, opts: opts, program: program}
+	inFiles := false
+	end := len(program)
+	for _, c := range comments {
+		switch {
+		case c.text == "---bootstrap---":
+			s.bootstrap = true
+			continue
+		case markers[c.text]:
+			if !inFiles && s.bootstrap {
+				// Returning from Sys.init lands at the end of the bootstrap.
+				end = c.addr
+			}
+			inFiles = true
+			continue
+		case !inFiles:
+			// the bootstrap and the shared routines of the compact mode
+			continue
+		}
+		i := len(s.addrs)
+		if i >= len(cmds) || c.text != cmds[i].String() {
+			return nil, fmt.Errorf("the translated code does not match the VM commands at %q", c.text)
+		}
+		s.addrs = append(s.addrs, c.addr)
+	}
+	if len(s.addrs) != len(cmds) {
+		return nil, fmt.Errorf("the translated code has %v VM commands, want %v", len(s.addrs), len(cmds))
+	}
+	s.addrs = append(s.addrs, end)
+	s.starts = make([]bool, len(program)+1)
+	for _, a := range s.addrs {
+		s.starts[a] = true
+	}
+	return s, nil
+}
+
+func diagErrors(diags []translator.Diagnostic) error {
+	errs := make([]error, len(diags))
+	for i, d := range diags {
+		errs[i] = d
+	}
+	return errors.Join(errs...)
+}
+
+// run executes up to steps VM commands, checking after each that the Hack
+// code continued at the code of the next command, and comparing the RAM
+// after every command if compareAll is set, or else after returns only.
+func (s *session) run(compareAll bool, steps uint64) (*Divergence, error) {
+	m := vm.New()
+	if diags := m.Load(s.files); len(diags) > 0 {
+		return nil, diagErrors(diags)
+	}
+	cpu := emulator.New()
+	if err := cpu.Load(s.program); err != nil {
+		return nil, err
+	}
+	for addr, v := range s.opts.RAM {
+		m.RAM[addr] = v
+		cpu.RAM[addr] = v
+	}
+
+	if s.bootstrap {
+		if err := m.Bootstrap(); err != nil {
+			return nil, err
+		}
+		d := &Divergence{Command: translator.Command{Type: translator.CallCommand, Name: "call", Arg1: "Sys.init"}}
+		if d.Msg = s.advance(cpu, s.addrs[m.PC]); d.Msg != "" {
+			return d, nil
+		}
+		if d.Diffs = s.compare(m, cpu, d.Command); len(d.Diffs) > 0 {
+			return d, nil
+		}
+	} else {
+		// The translated code runs from the first command, Sys.init or not,
+		// after jumping over the shared routines in the compact mode.
+		m.PC = 0
+		if int(cpu.PC) != s.addrs[0] {
+			if msg := s.advance(cpu, s.addrs[0]); msg != "" {
+				return nil, fmt.Errorf("the translated code does not start with the first command: %v", msg)
+			}
+		}
+	}
+
+	for step := uint64(1); step <= steps && !m.Halted; step++ {
+		cmd, file, _ := m.Next()
+		d := &Divergence{File: file, Line: cmd.Line, Function: m.Function(), Command: cmd, Step: step}
+		pc := m.PC
+		if err := m.Step(); err != nil {
+			return nil, err
+		}
+		// Labels and functions without locals have no code to run.
+		if s.addrs[pc] != s.addrs[pc+1] {
+			if d.Msg = s.advance(cpu, s.addrs[m.PC]); d.Msg != "" {
+				return d, nil
+			}
+		}
+		if compareAll || cmd.Type == translator.ReturnCommand || m.Halted {
+			if d.Diffs = s.compare(m, cpu, cmd); len(d.Diffs) > 0 {
+				return d, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// advance runs the CPU until it reaches the code of a VM command, and
+// describes what went wrong if that is not target.
+func (s *session) advance(cpu *emulator.Machine, target int) string {
+	for i := 0; i < maxInstructions; i++ {
+		cpu.Step()
+		pc := int(cpu.PC)
+		if pc < len(s.starts) && s.starts[pc] {
+			if pc != target {
+				return fmt.Sprintf("the Hack code continued at ROM[%v] instead of ROM[%v]", pc, target)
+			}
+			return ""
+		}
+		if cpu.Halted {
+			break
+		}
+	}
+	return fmt.Sprintf("the Hack code did not reach ROM[%v] but stopped at ROM[%v]", target, cpu.PC)
+}
+
+// compare returns the RAM words that differ after cmd, leaving out R13-R15,
+// which the translated code uses as scratch registers, and the free part of
+// the stack above SP. The return addresses in the frames on the stack are
+// indexes of VM commands in the interpreter; they are compared as ROM
+// addresses.
+func (s *session) compare(m *vm.Machine, cpu *emulator.Machine, cmd translator.Command) []Diff {
+	sp := int(m.RAM[vm.SP])
+	// Between the commands of straight-line code, the translated code may
+	// keep the top of the stack in D, not counted by SP, and the result of a
+	// comparison as x-y until the next command.
+	cached := int(cpu.RAM[vm.SP]) == sp-1 && sp > stackBase && cmd.Type != translator.ReturnCommand
+	comparison := cmd.Name == "eq" || cmd.Name == "lt" || cmd.Name == "gt"
+	returns := map[int]bool{}
+	for frame := int(m.RAM[vm.LCL]); frame-5 >= stackBase && frame <= sp; {
+		returns[frame-5] = true
+		caller := int(m.RAM[frame-4])
+		if caller >= frame {
+			break
+		}
+		frame = caller
+	}
+
+	var diffs []Diff
+	for addr, v := range m.RAM {
+		if addr >= 13 && addr <= 15 || addr >= max(sp, stackBase) && addr < heapBase {
+			continue
+		}
+		if returns[addr] && v >= 0 && int(v) < len(s.addrs) {
+			v = int16(s.addrs[v])
+		}
+		hack := cpu.RAM[addr]
+		if cached {
+			if addr == vm.SP || addr == sp-1 && comparison {
+				continue
+			}
+			if addr == sp-1 {
+				hack = cpu.D
+			}
+		}
+		if hack != v {
+			diffs = append(diffs, Diff{Addr: addr, VM: v, Hack: hack})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,429 @@
+package difftest
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nand2tetris-5/emulator"
+	"nand2tetris-7/translator"
+	"nand2tetris-7/vm"
+)
+
+func readFiles(t *testing.T, pattern string) []translator.File {
+	t.Helper()
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := translator.ReadFiles(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestAgree(t *testing.T) {
+	tests := []struct {
+		pattern string
+		ram     map[int]int16
+	}{
+		{"../StackArithmetic/SimpleAdd/*.vm", map[int]int16{0: 256}},
+		{"../StackArithmetic/StackTest/*.vm", map[int]int16{0: 256}},
+		{"../MemoryAccess/BasicTest/*.vm", map[int]int16{0: 256, 1: 300, 2: 400, 3: 3000, 4: 3010}},
+		{"../MemoryAccess/PointerTest/*.vm", map[int]int16{0: 256}},
+		{"../MemoryAccess/StaticTest/*.vm", map[int]int16{0: 256}},
+		{"../../8/ProgramFlow/BasicLoop/*.vm", map[int]int16{0: 256, 1: 300, 2: 400, 400: 3}},
+		{"../../8/ProgramFlow/FibonacciSeries/*.vm", map[int]int16{0: 256, 1: 300, 2: 400, 400: 6, 401: 3000}},
+		{"../../8/FunctionCalls/FibonacciElement/*.vm", nil},
+		{"../../8/FunctionCalls/StaticsTest/*.vm", nil},
+		{"../../8/FunctionCalls/NestedCall/*.vm", nil},
+	}
+	modes := []struct {
+		name       string
+		translator translator.Translator
+	}{
+		{"", translator.Translator{}},
+		{"/compact", translator.Translator{Compact: true}},
+		{"/cachetop", translator.Translator{CacheTop: true}},
+		{"/compact/cachetop", translator.Translator{Compact: true, CacheTop: true}},
+	}
+	for _, tt := range tests {
+		for _, mode := range modes {
+			t.Run(tt.pattern+mode.name, func(t *testing.T) {
+				opts := Options{Translator: mode.translator, RAM: tt.ram, Steps: 10000}
+				d, err := Run(readFiles(t, tt.pattern), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if d != nil {
+					t.Fatal(d)
+				}
+				// The comparisons after every command must agree as well.
+				s, err := newSession(readFiles(t, tt.pattern), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if d, err := s.run(true, opts.Steps); d != nil || err != nil {
+					t.Fatal(d, err)
+				}
+			})
+		}
+	}
+}
+
+func TestComparisonOverflow(t *testing.T) {
+	src := "push constant 0\npush constant 32767\nsub\npush constant 1\nsub\npush constant 1\nlt\npush constant 5\n"
+	files := []translator.File{{Name: "T.vm", Src: []byte(src)}}
+	d, err := Run(files, Options{RAM: map[int]int16{0: 256}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// -32768 < 1, but -32768 - 1 overflows in the D=x-y of the translated lt.
+	if d == nil {
+		t.Fatal("no divergence")
+	}
+	if d.Line != 7 || d.Step != 7 || len(d.Diffs) != 1 || d.Diffs[0] != (Diff{Addr: 256, VM: -1, Hack: 0}) {
+		t.Errorf("got %v", d)
+	}
+}
+
+func TestCachedTopFallsIntoLabel(t *testing.T) {
+	src := "function Sys.init 0\npush constant 1\nlabel END\ngoto END\n"
+	files := []translator.File{{Name: "Sys.vm", Src: []byte(src)}}
+	for _, compact := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compact=%v", compact), func(t *testing.T) {
+			opts := Options{Translator: translator.Translator{Compact: compact, CacheTop: true}, Steps: 20}
+			s, err := newSession(files, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d, err := s.run(true, opts.Steps); d != nil || err != nil {
+				t.Fatal(d, err)
+			}
+		})
+	}
+}
+
+func TestTopLevelLabels(t *testing.T) {
+	files := []translator.File{
+		{Name: "A.vm", Src: []byte("goto LOOP\npush constant 7\nlabel LOOP\npush constant 1\n")},
+		{Name: "B.vm", Src: []byte("goto LOOP\npush constant 8\nlabel LOOP\npush constant 2\n")},
+	}
+	d, err := Run(files, Options{RAM: map[int]int16{0: 256}})
+	if d != nil || err != nil {
+		t.Fatal(d, err)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	src := "// comment\n@i\nM=1\n(LOOP)\n@i\nD=M // inline\n@LOOP\nD;JGT\n@R13\nAM=M-1\n"
+	program, comments, err := assemble([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{
+		16, 0b1110111111001000,
+		16, 0b1111110000010000,
+		2, 0b1110001100000001,
+		13, 0b1111110010101000,
+	}
+	if len(program) != len(want) {
+		t.Fatalf("got %v instructions, want %v", len(program), len(want))
+	}
+	for i := range want {
+		if program[i] != want[i] {
+			t.Errorf("ROM[%v] = %016b, want %016b", i, program[i], want[i])
+		}
+	}
+	if len(comments) != 1 || comments[0] != (comment{text: "comment", addr: 0}) {
+		t.Errorf("comments = %v", comments)
+	}
+}
+
+// checkFinalState runs files to the end in the VM interpreter and,
+// translated by tr, in the CPU emulator, and compares the results. It is for
+// the optimized programs, which cannot be compared command by command.
+func checkFinalState(t *testing.T, files []translator.File, tr translator.Translator, ram map[int]int16) {
+	t.Helper()
+	out, diags := tr.Translate(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	program, _, err := assemble(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := emulator.New()
+	if err := cpu.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	m := vm.New()
+	if diags := m.Load(files); len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	for addr, v := range ram {
+		cpu.RAM[addr], m.RAM[addr] = v, v
+	}
+	if ram == nil {
+		if err := m.Bootstrap(); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		m.PC = 0
+	}
+	if err := m.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Halted {
+		t.Fatal("the VM program did not halt")
+	}
+	for i := 0; i < 1000000 && !cpu.Halted && int(cpu.PC) < len(program); i++ {
+		cpu.Step()
+	}
+	// The return addresses differ, so only the results are compared. The
+	// inlined functions use temp for their arguments and locals.
+	sp := int(m.RAM[vm.SP])
+	for addr := 0; addr < 4096; addr++ {
+		if addr >= 13 && addr <= 15 || addr >= stackBase && addr < sp-1 || addr >= sp && addr < heapBase ||
+			tr.InlineSize > 0 && addr >= 5 && addr <= 12 {
+			continue
+		}
+		if m.RAM[addr] != cpu.RAM[addr] {
+			t.Errorf("RAM[%v] is %v in the VM but %v in Hack", addr, m.RAM[addr], cpu.RAM[addr])
+		}
+	}
+}
+
+func TestOptimized(t *testing.T) {
+	tests := []struct {
+		pattern string
+		ram     map[int]int16
+	}{
+		{"../StackArithmetic/StackTest/*.vm", map[int]int16{0: 256}},
+		{"../MemoryAccess/BasicTest/*.vm", map[int]int16{0: 256, 1: 300, 2: 400, 3: 3000, 4: 3010}},
+		{"../../8/ProgramFlow/FibonacciSeries/*.vm", map[int]int16{0: 256, 1: 300, 2: 400, 400: 6, 401: 3000}},
+		{"../../8/FunctionCalls/FibonacciElement/*.vm", nil},
+		{"../../8/FunctionCalls/StaticsTest/*.vm", nil},
+	}
+	modes := []struct {
+		name     string
+		cacheTop bool
+	}{
+		{"", false},
+		{"/cachetop", true},
+	}
+	for _, tt := range tests {
+		for _, mode := range modes {
+			t.Run(tt.pattern+mode.name, func(t *testing.T) {
+				tr := translator.Translator{Optimize: true, CacheTop: mode.cacheTop, PruneFunctions: true}
+				checkFinalState(t, readFiles(t, tt.pattern), tr, tt.ram)
+			})
+		}
+	}
+}
+
+func TestStrengthReduction(t *testing.T) {
+	var sb strings.Builder
+	i := 0
+	for _, x := range []int{7, -7, 12345, -12345, -32768} {
+		for _, op := range []string{"push constant 2\nmul", "push constant 16\nmul", "push constant 1\nneg\nmul",
+			"push constant 1\nneg\ndiv", "push constant 1\ndiv", "push constant 0\nshl"} {
+			if x == -32768 {
+				sb.WriteString("push constant 32767\nnot\npop local 0\n")
+			} else if x < 0 {
+				fmt.Fprintf(&sb, "push constant %v\nneg\npop local 0\n", -x)
+			} else {
+				fmt.Fprintf(&sb, "push constant %v\npop local 0\n", x)
+			}
+			fmt.Fprintf(&sb, "push local 0\n%v\npop static %v\n", op, i)
+			i++
+		}
+	}
+	files := []translator.File{{Name: "T.vm", Src: []byte(sb.String())}}
+	for _, mode := range []struct {
+		name     string
+		cacheTop bool
+	}{{"", false}, {"/cachetop", true}} {
+		t.Run("T.vm"+mode.name, func(t *testing.T) {
+			tr := translator.Translator{Optimize: true, CacheTop: mode.cacheTop}
+			checkFinalState(t, files, tr, map[int]int16{0: 256, 1: 300})
+		})
+	}
+}
+
+func TestInlined(t *testing.T) {
+	sys := `function Sys.init 0
+push constant 3000
+call Point.new 1
+pop static 0
+push static 0
+call Point.getX 1
+push constant 7
+neg
+call Math.abs 1
+add
+push constant 2
+call Math.abs 1
+add
+push constant 1
+push constant 2
+call Math.max 2
+add
+pop static 1
+push pointer 0
+pop static 2
+label END
+goto END
+`
+	point := `function Point.new 0
+push argument 0
+pop pointer 0
+push constant 5
+pop this 0
+push pointer 0
+return
+function Point.getX 0
+push argument 0
+pop pointer 0
+push this 0
+return
+`
+	math := `function Math.abs 1
+push argument 0
+pop local 0
+push local 0
+push constant 0
+lt
+not
+if-goto POSITIVE
+push local 0
+neg
+return
+label POSITIVE
+push local 0
+return
+function Math.max 0
+push argument 0
+push argument 1
+gt
+if-goto FIRST
+push argument 1
+goto END
+label FIRST
+push argument 0
+label END
+return
+`
+	files := []translator.File{
+		{Name: "Sys.vm", Src: []byte(sys)},
+		{Name: "Point.vm", Src: []byte(point)},
+		{Name: "Math.vm", Src: []byte(math)},
+	}
+	for _, cacheTop := range []bool{false, true} {
+		tr := translator.Translator{InlineSize: 20, CacheTop: cacheTop, Optimize: cacheTop}
+		checkFinalState(t, files, tr, nil)
+	}
+	out, _ := (&translator.Translator{InlineSize: 20}).Translate(files)
+	if strings.Contains(string(out), "call Math.abs") || strings.Contains(string(out), "call Point.getX") {
+		t.Error("calls were not inlined")
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// Main.count(n, acc) returns acc+n, calling itself n times in tail
+	// position, with more arguments than Sys.init has.
+	src := func(n int) []translator.File {
+		sys := fmt.Sprintf(`function Sys.init 0
+push constant %v
+push constant 1
+call Main.count 2
+pop static 0
+label END
+goto END
+`, n)
+		main := `function Main.count 1
+push argument 0
+push constant 0
+eq
+if-goto DONE
+push argument 0
+push constant 1
+sub
+push argument 1
+push constant 1
+add
+call Main.count 2
+return
+label DONE
+push argument 1
+return
+`
+		return []translator.File{{Name: "Sys.vm", Src: []byte(sys)}, {Name: "Main.vm", Src: []byte(main)}}
+	}
+	for _, cacheTop := range []bool{false, true} {
+		checkFinalState(t, src(20), translator.Translator{TailCalls: true, CacheTop: cacheTop}, nil)
+	}
+
+	// Without tail calls, 5000 frames would overflow the stack.
+	out, diags := (&translator.Translator{TailCalls: true}).Translate(src(5000))
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	program, _, err := assemble(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := emulator.New()
+	if err := cpu.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	maxSP := int16(0)
+	for i := 0; i < 5000000 && cpu.RAM[16] == 0; i++ {
+		cpu.Step()
+		maxSP = max(maxSP, cpu.RAM[vm.SP])
+	}
+	if cpu.RAM[16] != 5001 {
+		t.Errorf("Main.count returned %v, want 5001", cpu.RAM[16])
+	}
+	if maxSP > 300 {
+		t.Errorf("the stack grew up to %v", maxSP)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	values := []int16{0, 1, -1, 2, -2, 3, 7, -7, 15, 16, 100, -100, 255, 12345, -12345, 32767, -32768}
+	push := func(sb *strings.Builder, v int16) {
+		switch {
+		case v == -32768:
+			sb.WriteString("push constant 32767\nnot\n")
+		case v < 0:
+			fmt.Fprintf(sb, "push constant %v\nneg\n", -v)
+		default:
+			fmt.Fprintf(sb, "push constant %v\n", v)
+		}
+	}
+	for _, op := range []string{"mul", "div", "shl", "shr"} {
+		var sb strings.Builder
+		for _, x := range values {
+			// x/0 is 0 in both machines.
+			for i, y := range values {
+				push(&sb, x)
+				push(&sb, y)
+				fmt.Fprintf(&sb, "%v\npop static %v\n", op, i)
+			}
+		}
+		files := []translator.File{{Name: "T.vm", Src: []byte(sb.String())}}
+		for _, tr := range []translator.Translator{{}, {Compact: true}, {CacheTop: true}} {
+			opts := Options{Translator: tr, RAM: map[int]int16{0: 256}, Steps: 10000}
+			s, err := newSession(files, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d, err := s.run(true, opts.Steps); d != nil || err != nil {
+				t.Fatal(op, d, err)
+			}
+		}
+	}
+}
@@ -1,3 +1,7 @@
 module nand2tetris-7
 
 go 1.23.2
+
+require nand2tetris-5 v0.0.0
+
+replace nand2tetris-5 => ../5
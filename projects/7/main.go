@@ -1,16 +1,39 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+
+	"nand2tetris-7/difftest"
+	"nand2tetris-7/translator"
+	"nand2tetris-7/vm"
 )
 
 func main() {
-	src := flag.String("src", "", "source file/dir path")
-	dest := flag.String("dest", "", "output file path")
+	src := flag.String("src", "", "source .vm or .vmbc bytecode file, or directory path, or a VM emulator .tst script to run; more source paths may follow the flags")
+	dest := flag.String("dest", "", "output file path (default <Dir>/<Dir>.asm for a directory, X.asm for X.vm)")
+	bootstrap := flag.Bool("bootstrap", false, "emit code setting SP=256 and calling Sys.init (default true only when Sys.init is defined)")
+	compact := flag.Bool("compact", false, "share the code of call, return and comparisons in routines, for programs too large for the ROM")
+	cacheTop := flag.Bool("cachetop", false, "keep the top of the stack in the D register between straight-line commands")
+	optimize := flag.Bool("optimize", false, "fold constants and remove useless and unreachable VM commands before translating them")
+	inline := flag.Int("inline", 0, "replace the calls to functions of at most this many commands by their bodies, keeping their arguments and locals in the temp entries the caller does not use")
+	tailCalls := flag.Bool("tailcalls", false, "write the calls followed by a return as jumps reusing the frame of the caller")
+	statics := flag.Bool("statics", false, "print the RAM address the assembler gives to every static variable, and warn about the undefined symbols")
+	emit := flag.String("emit", "asm", "what to write to -dest: asm, vmbc for VM bytecode, or vm for .vm text files in the -dest directory")
+	sourceMap := flag.String("sourcemap", "", "also write to this path, in JSON, the VM file, line and function of every range of instructions")
+	prune := flag.Bool("prune", false, "leave out the functions Sys.init never calls")
+	run := flag.Bool("run", false, "run the VM program in the VM interpreter instead of translating it")
+	diff := flag.Bool("diff", false, "run the VM program in the VM interpreter and, translated, in the CPU emulator, and report the first VM command where they differ")
+	steps := flag.Uint64("steps", 10_000_000, "max number of VM commands to execute with -run or -diff")
+	ram := ramValues{vm.SP: 256}
+	flag.Var(ram, "set", "set RAM[addr] to value before -run or -diff, like the set commands of the test scripts, as addr=value; may be repeated")
 	flag.Parse()
 
 	if src == nil || *src == "" {
@@ -18,48 +41,215 @@ func main() {
 		return
 	}
 
-	if dest == nil || *dest == "" {
-		fmt.Println("not set output path")
+	if strings.HasSuffix(*src, ".tst") {
+		if err := vm.RunFile(*src); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("End of script - Comparison ended successfully")
 		return
 	}
 
-	p := NewParserWithFile(*dest)
-	defer func() {
-		if err := p.Close(); err != nil {
-			fmt.Printf("Error while closeing files: %v\n", err)
+	srcs := append([]string{*src}, flag.Args()...)
+	paths, err := translator.VMFiles(srcs...)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	ext, ok := map[string]string{"asm": ".asm", "vmbc": ".vmbc", "vm": ""}[*emit]
+	if !ok {
+		fmt.Printf("unknown -emit %q\n", *emit)
+		os.Exit(1)
+	}
+	if *dest == "" && !*run && !*diff {
+		if len(srcs) > 1 || ext == "" {
+			fmt.Println("not set output path, needed for several source paths or -emit vm")
+			return
+		}
+		*dest = defaultDest(*src, ext)
+	}
+
+	t := translator.Translator{Compact: *compact, CacheTop: *cacheTop, Optimize: *optimize, InlineSize: *inline, TailCalls: *tailCalls, PruneFunctions: *prune, StaticMap: *statics, Report: os.Stdout}
+	// The bootstrap is on by default only when there is a Sys.init to call,
+	// so that single files of project 7 run without one.
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "bootstrap" {
+			return
+		}
+		if *bootstrap {
+			t.Bootstrap = translator.BootstrapOn
+		} else {
+			t.Bootstrap = translator.BootstrapOff
 		}
-	}()
+	})
 
-	if strings.HasSuffix(filepath.Base(*src), ".vm") {
-		fmt.Printf("Processing file: %s\n", *src)
-		file, err := os.Open(*src)
+	for _, path := range paths {
+		fmt.Printf("Processing file: %s\n", path)
+	}
+	files, diags, err := translator.ReadProgram(paths...)
+	if err != nil {
+		fmt.Printf("Failed to read files: %v\n", err)
+		os.Exit(1)
+	}
+	if len(diags) > 0 {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+		os.Exit(1)
+	}
+	if *run {
+		if err := runVM(files, ram, *steps); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *diff {
+		// Like -run, programs without a bootstrap start with the -set RAM.
+		opts := difftest.Options{Translator: t, RAM: ram, Steps: *steps}
+		text := make([]translator.File, len(files))
+		for i, f := range files {
+			text[i] = translator.File{Name: f.Name, Src: translator.FormatCommands(f.Commands)}
+		}
+		d, err := difftest.Run(text, opts)
 		if err != nil {
-			fmt.Printf("Failed to open file %s: %v\n", *src, err)
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		p.Do(file)
-	} else {
-		err := filepath.WalkDir(*src, func(path string, d os.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if d.IsDir() {
-				return nil
-			}
-			if strings.HasSuffix(d.Name(), ".vm") {
-				fmt.Printf("Processing file: %s\n", path)
-				file, err := os.Open(path)
-				if err != nil {
-					fmt.Printf("Failed to open file %s: %v\n", path, err)
-					return nil
-				}
-				p.Do(file)
+		if d != nil {
+			fmt.Println(d)
+			os.Exit(1)
+		}
+		fmt.Println("the VM interpreter and the translated code agree")
+		return
+	}
+	switch *emit {
+	case "vmbc":
+		b, err := translator.EncodeBytecode(files)
+		if err == nil {
+			err = os.WriteFile(*dest, b, 0644)
+		}
+		if err != nil {
+			fmt.Printf("Failed to write %s: %v\n", *dest, err)
+			os.Exit(1)
+		}
+		return
+	case "vm":
+		for _, f := range files {
+			path := filepath.Join(*dest, filepath.Base(f.Name))
+			if err := os.WriteFile(path, translator.FormatCommands(f.Commands), 0644); err != nil {
+				fmt.Printf("Failed to write %s: %v\n", path, err)
+				os.Exit(1)
 			}
-			return nil
-		})
-
+		}
+		return
+	}
+	out, sources, diags := t.TranslateParsed(files)
+	if len(diags) > 0 {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*dest, out, 0644); err != nil {
+		fmt.Printf("Failed to write %s: %v\n", *dest, err)
+		os.Exit(1)
+	}
+	if *sourceMap != "" {
+		b, err := json.MarshalIndent(sources, "", "\t")
+		if err == nil {
+			err = os.WriteFile(*sourceMap, b, 0644)
+		}
 		if err != nil {
-			fmt.Printf("Error while walking directory: %v\n", err)
-			return
+			fmt.Printf("Failed to write %s: %v\n", *sourceMap, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// defaultDest returns the output path with extension ext for src like the
+// standard VM translator: X.asm next to X.vm, and Dir/Dir.asm for a
+// directory Dir.
+func defaultDest(src, ext string) string {
+	if e := filepath.Ext(src); e == ".vm" || e == ".vmbc" {
+		return strings.TrimSuffix(src, e) + ext
+	}
+	dir := filepath.Clean(src)
+	name := filepath.Base(dir)
+	if name == "." || name == string(filepath.Separator) {
+		if abs, err := filepath.Abs(dir); err == nil {
+			name = filepath.Base(abs)
 		}
 	}
+	return filepath.Join(dir, name+ext)
+}
+
+// runVM runs files in the VM interpreter from the bootstrap, or from the
+// first command when there is no Sys.init, with the RAM set by -set, and
+// prints the state at the end.
+func runVM(files []translator.ParsedFile, ram ramValues, steps uint64) error {
+	m := vm.New()
+	if diags := m.LoadParsed(files); len(diags) > 0 {
+		return errors.Join(diagErrors(diags)...)
+	}
+	for addr, v := range ram {
+		m.RAM[addr] = v
+	}
+	if err := m.Bootstrap(); err != nil {
+		m.Reset()
+	}
+	if err := m.Run(steps); err != nil {
+		return err
+	}
+	fmt.Printf("executed %v VM commands, halted: %v, function: %q\n", m.Steps, m.Halted, m.Function())
+	var sb strings.Builder
+	for i := 0; i < 16; i++ {
+		sb.WriteString(fmt.Sprintf("RAM[%v]=%v ", i, m.RAM[i]))
+	}
+	fmt.Println(strings.TrimSpace(sb.String()))
+	if sp := int(m.RAM[vm.SP]); sp > 256 && sp <= len(m.RAM) {
+		fmt.Printf("stack top: %v\n", m.RAM[sp-1])
+	}
+	return nil
+}
+
+// ramValues are the RAM words set by -set addr=value.
+type ramValues map[int]int16
+
+func (r ramValues) String() string {
+	addrs := make([]int, 0, len(r))
+	for addr := range r {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	s := make([]string, len(addrs))
+	for i, addr := range addrs {
+		s[i] = fmt.Sprintf("%v=%v", addr, r[addr])
+	}
+	return strings.Join(s, ",")
+}
+
+func (r ramValues) Set(s string) error {
+	a, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("%q is not addr=value", s)
+	}
+	addr, err := strconv.Atoi(a)
+	if err != nil || addr < 0 || addr > 32767 {
+		return fmt.Errorf("invalid RAM address %q", a)
+	}
+	value, err := strconv.ParseInt(v, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid value %q", v)
+	}
+	r[addr] = int16(value)
+	return nil
+}
+
+func diagErrors(diags []translator.Diagnostic) []error {
+	errs := make([]error, len(diags))
+	for i, d := range diags {
+		errs[i] = d
+	}
+	return errs
 }
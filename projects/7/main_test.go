@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultDest(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{"FunctionCalls/NestedCall", "FunctionCalls/NestedCall/NestedCall.asm"},
+		{"FunctionCalls/NestedCall/", "FunctionCalls/NestedCall/NestedCall.asm"},
+		{"StackArithmetic/SimpleAdd/SimpleAdd.vm", "StackArithmetic/SimpleAdd/SimpleAdd.asm"},
+		{"StackArithmetic/SimpleAdd/SimpleAdd.vmbc", "StackArithmetic/SimpleAdd/SimpleAdd.asm"},
+	}
+	for _, tt := range tests {
+		if got := defaultDest(tt.src, ".asm"); got != filepath.FromSlash(tt.want) {
+			t.Errorf("defaultDest(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := defaultDest(".", ".vmbc"), filepath.Base(wd)+".vmbc"; got != want {
+		t.Errorf("defaultDest(\".\") = %q, want %q", got, want)
+	}
+}
+
+func TestRAMValues(t *testing.T) {
+	ram := ramValues{0: 256}
+	for _, s := range []string{"1=300", "400=-3", "0=261"} {
+		if err := ram.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := ram.String(), "0=261,1=300,400=-3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, s := range []string{"1", "x=1", "-1=0", "32768=0", "1=40000"} {
+		if err := ram.Set(s); err == nil {
+			t.Errorf("no error setting %q", s)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package translator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// ParsedFile is the commands of a .vm file, parsed or decoded from bytecode.
+type ParsedFile struct {
+	Name     string
+	Commands []Command
+}
+
+// ParseFiles parses files. All the errors of all the files are returned.
+func ParseFiles(files []File) ([]ParsedFile, []Diagnostic) {
+	var diags []Diagnostic
+	parsed := make([]ParsedFile, len(files))
+	for i, f := range files {
+		cmds, ds := Parse(f)
+		parsed[i] = ParsedFile{Name: f.Name, Commands: cmds}
+		diags = append(diags, ds...)
+	}
+	return parsed, diags
+}
+
+// The bytecode of a program is, with the numbers as unsigned varints and the
+// strings as their length followed by their bytes:
+//
+//	"VMBC" version
+//	the number of names, and the names of the functions and labels
+//	the number of files, and for each file:
+//	  its name
+//	  the number of its static variables, and their indexes: the static table
+//	  the number of its commands, and for each command its opcode, its line
+//	  and its operands:
+//	    push, pop:              segment, index, in the static table for static
+//	    label, gotos:           name
+//	    function, call:         name, number of locals or arguments
+//
+// The opcode and the segment take a byte.
+const (
+	bytecodeMagic   = "VMBC"
+	bytecodeVersion = 1
+)
+
+// opcodes are the commands in the order of their opcodes. They are the
+// commands of the text only: the IR of the optimizer, like if-not-goto, has
+// no text to be written back to, and is not encoded.
+var opcodes = []string{
+	"add", "sub", "neg", "eq", "gt", "lt", "and", "or", "not",
+	"push", "pop", "label", "goto", "if-goto", "function", "call", "return",
+	"mul", "div", "shl", "shr",
+}
+
+// segments are the segments in the order of their codes.
+var segments = []Segment{Local, Argument, This, That, Pointer, Temp, Constant, Static}
+
+// EncodeBytecode encodes the commands of files, which must be commands of
+// the text.
+func EncodeBytecode(files []ParsedFile) ([]byte, error) {
+	names := []string{}
+	nameIDs := map[string]int{}
+	for _, f := range files {
+		for _, cmd := range f.Commands {
+			switch cmd.Type {
+			case LabelCommand, GotoCommand, IfCommand, FunctionCommand, CallCommand:
+				if _, ok := nameIDs[cmd.Arg1]; !ok {
+					nameIDs[cmd.Arg1] = len(names)
+					names = append(names, cmd.Arg1)
+				}
+			}
+		}
+	}
+
+	b := append([]byte(bytecodeMagic), bytecodeVersion)
+	appendString := func(s string) {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	appendInt := func(i int) {
+		b = binary.AppendUvarint(b, uint64(i))
+	}
+	appendInt(len(names))
+	for _, name := range names {
+		appendString(name)
+	}
+	appendInt(len(files))
+	for _, f := range files {
+		appendString(f.Name)
+		var statics []int
+		for _, cmd := range f.Commands {
+			if (cmd.Type == PushCommand || cmd.Type == PopCommand) && Segment(cmd.Arg1) == Static && !contains(statics, cmd.Arg2) {
+				statics = append(statics, cmd.Arg2)
+			}
+		}
+		appendInt(len(statics))
+		for _, i := range statics {
+			appendInt(i)
+		}
+
+		appendInt(len(f.Commands))
+		for _, cmd := range f.Commands {
+			op := slices.Index(opcodes, cmd.Name)
+			if op < 0 {
+				return nil, fmt.Errorf("%v:%v: no opcode for %q", f.Name, cmd.Line, cmd.Name)
+			}
+			b = append(b, byte(op))
+			appendInt(cmd.Line)
+			switch cmd.Type {
+			case PushCommand, PopCommand:
+				seg := slices.Index(segments, Segment(cmd.Arg1))
+				if seg < 0 {
+					return nil, fmt.Errorf("%v:%v: unknown segment %q", f.Name, cmd.Line, cmd.Arg1)
+				}
+				b = append(b, byte(seg))
+				if segments[seg] == Static {
+					appendInt(slices.Index(statics, cmd.Arg2))
+				} else {
+					appendInt(cmd.Arg2)
+				}
+			case LabelCommand, GotoCommand, IfCommand:
+				appendInt(nameIDs[cmd.Arg1])
+			case FunctionCommand, CallCommand:
+				appendInt(nameIDs[cmd.Arg1])
+				appendInt(cmd.Arg2)
+			}
+		}
+	}
+	return b, nil
+}
+
+var errTruncated = errors.New("bytecode is truncated")
+
+// bytecodeReader reads the numbers and strings of bytecode, keeping the first
+// error.
+type bytecodeReader struct {
+	b   []byte
+	err error
+}
+
+func (r *bytecodeReader) int() int {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 || v > 1<<31 {
+		r.err = errTruncated
+		return 0
+	}
+	r.b = r.b[n:]
+	return int(v)
+}
+
+func (r *bytecodeReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) == 0 {
+		r.err = errTruncated
+		return 0
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *bytecodeReader) string() string {
+	n := r.int()
+	if r.err != nil {
+		return ""
+	}
+	if n > len(r.b) {
+		r.err = errTruncated
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+// count reads a number of items, each taking at least one byte.
+func (r *bytecodeReader) count() int {
+	n := r.int()
+	if n > len(r.b) {
+		r.err = errTruncated
+		return 0
+	}
+	return n
+}
+
+// DecodeBytecode decodes the files encoded by EncodeBytecode. The names and
+// the operands of push, pop, function and call are checked as the parser
+// checks them.
+func DecodeBytecode(b []byte) ([]ParsedFile, error) {
+	if !bytes.HasPrefix(b, []byte(bytecodeMagic)) || len(b) == len(bytecodeMagic) {
+		return nil, errors.New("not VM bytecode")
+	}
+	if v := b[len(bytecodeMagic)]; v != bytecodeVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %v", v)
+	}
+	r := &bytecodeReader{b: b[len(bytecodeMagic)+1:]}
+	names := make([]string, r.count())
+	for i := range names {
+		names[i] = r.string()
+		if err := validateLabelName(names[i]); r.err == nil && err != nil {
+			return nil, err
+		}
+	}
+	name := func() string {
+		id := r.int()
+		if id >= len(names) {
+			if r.err == nil {
+				r.err = fmt.Errorf("name %v is not defined", id)
+			}
+			return ""
+		}
+		return names[id]
+	}
+
+	files := make([]ParsedFile, r.count())
+	for fi := range files {
+		f := &files[fi]
+		f.Name = r.string()
+		statics := make([]int, r.count())
+		for i := range statics {
+			statics[i] = r.int()
+		}
+		f.Commands = make([]Command, r.count())
+		for i := range f.Commands {
+			op := int(r.byte())
+			if op >= len(opcodes) {
+				return nil, fmt.Errorf("%v: unknown opcode %v", f.Name, op)
+			}
+			cmd := Command{Type: commands[opcodes[op]], Name: opcodes[op], Line: r.int()}
+			switch cmd.Type {
+			case PushCommand, PopCommand:
+				seg := int(r.byte())
+				if seg >= len(segments) {
+					return nil, fmt.Errorf("%v:%v: unknown segment %v", f.Name, cmd.Line, seg)
+				}
+				cmd.Arg1, cmd.Arg2 = string(segments[seg]), r.int()
+				if segments[seg] == Static {
+					if cmd.Arg2 >= len(statics) {
+						return nil, fmt.Errorf("%v:%v: static %v is not in the static table", f.Name, cmd.Line, cmd.Arg2)
+					}
+					cmd.Arg2 = statics[cmd.Arg2]
+				}
+				// The operands are checked like those of the text.
+				if _, _, err := validatePushAndPop([]string{cmd.Name, cmd.Arg1, strconv.Itoa(cmd.Arg2)}); err != nil {
+					return nil, fmt.Errorf("%v:%v: %v", f.Name, cmd.Line, err)
+				}
+			case LabelCommand, GotoCommand, IfCommand:
+				cmd.Arg1 = name()
+			case FunctionCommand, CallCommand:
+				cmd.Arg1, cmd.Arg2 = name(), r.int()
+				if _, _, err := validateFuncAndCall([]string{cmd.Name, cmd.Arg1, strconv.Itoa(cmd.Arg2)}); r.err == nil && err != nil {
+					return nil, fmt.Errorf("%v:%v: %v", f.Name, cmd.Line, err)
+				}
+			}
+			f.Commands[i] = cmd
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.b) > 0 {
+		return nil, errors.New("bytecode has trailing data")
+	}
+	return files, nil
+}
+
+// FormatCommands writes cmds as .vm text, each command on its line when the
+// lines are increasing, so that the diagnostics about the text refer to the
+// same lines.
+func FormatCommands(cmds []Command) []byte {
+	var b bytes.Buffer
+	line := 1
+	for _, cmd := range cmds {
+		for ; line < cmd.Line; line++ {
+			b.WriteByte('\n')
+		}
+		b.WriteString(cmd.String())
+		b.WriteByte('\n')
+		line++
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,94 @@
+package translator
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBytecode(t *testing.T) {
+	var paths []string
+	for _, pattern := range []string{"../*/*/*.vm", "../../8/*/*/*.vm"} {
+		p, _ := filepath.Glob(pattern)
+		paths = append(paths, p...)
+	}
+	files, err := ReadFiles(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, diags := ParseFiles(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	b, err := EncodeBytecode(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeBytecode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, parsed) {
+		t.Error("the decoded files differ from the encoded ones")
+	}
+	size := 0
+	for _, f := range files {
+		size += len(f.Src)
+	}
+	if len(b) > size/3 {
+		t.Errorf("bytecode takes %v bytes for %v bytes of text", len(b), size)
+	}
+
+	// The text keeps the lines of the commands.
+	for _, f := range parsed {
+		cmds, diags := Parse(File{Name: f.Name, Src: FormatCommands(f.Commands)})
+		if len(diags) > 0 {
+			t.Fatal(diags)
+		}
+		if !reflect.DeepEqual(cmds, f.Commands) {
+			t.Errorf("%v changed through text", f.Name)
+		}
+	}
+
+	// The IR only command has no text, and no opcode.
+	ir := ParsedFile{Name: "F.vm", Commands: []Command{{Type: IfNotCommand, Name: "if-not-goto", Arg1: "L", Line: 3}}}
+	if _, err := EncodeBytecode([]ParsedFile{ir}); err == nil {
+		t.Error("no error encoding if-not-goto")
+	}
+
+	for _, bad := range [][]byte{nil, []byte("VMBC"), b[:len(b)-1], append(b, 0), []byte("VMBC\x02")} {
+		if _, err := DecodeBytecode(bad); err == nil {
+			t.Errorf("no error decoding %q", bad)
+		}
+	}
+}
+
+func TestDecodeInvalidBytecode(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  Command
+	}{
+		{"pointer 2", Command{Type: PushCommand, Name: "push", Arg1: string(Pointer), Arg2: 2, Line: 1}},
+		{"temp 8", Command{Type: PopCommand, Name: "pop", Arg1: string(Temp), Arg2: 8, Line: 1}},
+		{"pop constant", Command{Type: PopCommand, Name: "pop", Arg1: string(Constant), Arg2: 1, Line: 1}},
+		{"large index", Command{Type: PushCommand, Name: "push", Arg1: string(Local), Arg2: max15BitInt + 1, Line: 1}},
+		{"large static", Command{Type: PushCommand, Name: "push", Arg1: string(Static), Arg2: max15BitInt + 1, Line: 1}},
+		{"label name", Command{Type: LabelCommand, Name: "label", Arg1: "1L", Line: 1}},
+		{"goto name", Command{Type: GotoCommand, Name: "goto", Arg1: "", Line: 1}},
+		{"function name", Command{Type: FunctionCommand, Name: "function", Arg1: "Main f", Line: 1}},
+		{"call name", Command{Type: CallCommand, Name: "call", Arg1: "Main-f", Arg2: 1, Line: 1}},
+		{"locals", Command{Type: FunctionCommand, Name: "function", Arg1: "Main.f", Arg2: max15BitInt + 1, Line: 1}},
+		{"arguments", Command{Type: CallCommand, Name: "call", Arg1: "Main.f", Arg2: 1 << 20, Line: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := EncodeBytecode([]ParsedFile{{Name: "F.vm", Commands: []Command{tt.cmd}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if files, err := DecodeBytecode(b); err == nil {
+				t.Errorf("decoded %v", files[0].Commands)
+			}
+		})
+	}
+}
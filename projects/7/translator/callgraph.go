@@ -0,0 +1,56 @@
+package translator
+
+// pruneFunctions removes the functions that Sys.init does not call, directly
+// or through other functions, and returns their names in the order they are
+// defined. Nothing is removed when Sys.init is not defined.
+func pruneFunctions(parsed [][]Command) ([][]Command, []string) {
+	calls := map[string][]string{}
+	defined := map[string]bool{}
+	for _, cmds := range parsed {
+		function := ""
+		for _, cmd := range cmds {
+			switch cmd.Type {
+			case FunctionCommand:
+				function = cmd.Arg1
+				defined[function] = true
+			case CallCommand:
+				calls[function] = append(calls[function], cmd.Arg1)
+			}
+		}
+	}
+	if !defined[initFuncName] {
+		return parsed, nil
+	}
+
+	called := map[string]bool{initFuncName: true}
+	stack := []string{initFuncName}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, g := range calls[f] {
+			if !called[g] {
+				called[g] = true
+				stack = append(stack, g)
+			}
+		}
+	}
+
+	var removed []string
+	pruned := make([][]Command, len(parsed))
+	for i, cmds := range parsed {
+		// The commands before the first function are kept.
+		keep := true
+		for _, cmd := range cmds {
+			if cmd.Type == FunctionCommand {
+				keep = called[cmd.Arg1]
+				if !keep {
+					removed = append(removed, cmd.Arg1)
+				}
+			}
+			if keep {
+				pruned[i] = append(pruned[i], cmd)
+			}
+		}
+	}
+	return pruned, removed
+}
@@ -0,0 +1,1440 @@
+package translator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const max15BitInt = 32767
+const initFuncName = "Sys.init"
+
+type Segment string
+
+const (
+	Local    Segment = "local"
+	Argument Segment = "argument"
+	This     Segment = "this"
+	That     Segment = "that"
+	Pointer  Segment = "pointer"
+	Temp     Segment = "temp"
+	Constant Segment = "constant"
+	Static   Segment = "static"
+)
+
+var Segments = map[string]Segment{
+	"local":    Local,
+	"argument": Argument,
+	"this":     This,
+	"that":     That,
+	"pointer":  Pointer,
+	"temp":     Temp,
+	"constant": Constant,
+	"static":   Static,
+}
+
+// Labels numbers the labels generated in a translation unit, like END_EQ1 or
+// Main.fibonacci.return2, so that they are unique across its files.
+type Labels struct {
+	// scope prefixes the labels of a file, so that the files can be
+	// translated independently.
+	scope  string
+	counts map[string]int
+}
+
+func NewLabels() *Labels {
+	return &Labels{counts: map[string]int{}}
+}
+
+// NewFileLabels returns the labels of the file of class, Class$END_EQ1 and so
+// on, which are unique as long as the classes are.
+func NewFileLabels(class string) *Labels {
+	return &Labels{scope: class + "$", counts: map[string]int{}}
+}
+
+// Next returns the next label for prefix, numbered from 1.
+func (l *Labels) Next(prefix string) string {
+	l.counts[prefix]++
+	return fmt.Sprintf("%v%v%v", l.scope, prefix, l.counts[prefix])
+}
+
+const (
+	minSP    = 256
+	tempBase = 5
+	// staticBase is the address the assembler gives to the first variable.
+	staticBase = 16
+)
+
+type CodeWriter struct {
+	wr          *bufio.Writer
+	srcFileName string
+	newLineChar string
+	sb          strings.Builder
+	symbols     *Labels
+
+	// function is the function being translated, labels are scoped to it.
+	function string
+	labels   map[string]bool
+	gotos    []labelRef
+
+	// line is the VM line being translated, used to report errors.
+	line  int
+	diags []Diagnostic
+	// pc counts the instructions written, sources maps them to VM lines.
+	pc      int
+	sources []SourceRange
+
+	// Compact makes call, return, eq, gt and lt jump to the shared routines
+	// written by Runtime instead of inlining their code.
+	Compact bool
+	// CacheTop keeps the top of the stack in D between the commands of
+	// straight-line code. It is written to the stack before labels, gotos,
+	// calls and returns.
+	CacheTop bool
+	top      topState
+	// jump is the jump of the comparison cached in D when top is topCompare.
+	jump string
+}
+
+// topState tells where the top of the stack is when CacheTop is set.
+type topState int
+
+const (
+	topInMemory topState = iota
+	// topInD is the top of the stack in D, not counted by SP.
+	topInD
+	// topCompare is the result of a comparison of x and y, not counted by
+	// SP either, with x-y in D.
+	topCompare
+)
+
+type labelRef struct {
+	label string
+	line  int
+}
+
+func NewCodeWriter(srcFileName string, wr io.Writer, symbols *Labels) CodeWriter {
+	writer := bufio.NewWriterSize(wr, 1048576) // default is 1MiB
+
+	return CodeWriter{
+		wr:          writer,
+		srcFileName: srcFileName,
+		newLineChar: "\n",
+		sb:          strings.Builder{},
+		symbols:     symbols,
+		labels:      map[string]bool{},
+	}
+}
+
+// SourceRange tells that the instructions from Start up to End come from a
+// VM command. File is empty for the code not coming from a .vm file.
+type SourceRange struct {
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Function string `json:"function"`
+}
+
+// SetLine sets the VM line the following commands come from.
+func (cw *CodeWriter) SetLine(line int) {
+	cw.endSource()
+	cw.line = line
+	cw.sources = append(cw.sources, SourceRange{Start: cw.pc, Line: line})
+}
+
+// endSource ends the range of the previous line, in the function it has
+// defined or is in.
+func (cw *CodeWriter) endSource() {
+	if n := len(cw.sources); n > 0 {
+		cw.sources[n-1].End = cw.pc
+		cw.sources[n-1].Function = cw.function
+	}
+}
+
+// Sources returns the ranges of the instructions written for each line,
+// from the first instruction written by cw, leaving out the lines without
+// instructions. It is complete after Flush.
+func (cw *CodeWriter) Sources() []SourceRange {
+	cw.endSource()
+	var sources []SourceRange
+	for _, r := range cw.sources {
+		if r.End > r.Start {
+			sources = append(sources, r)
+		}
+	}
+	return sources
+}
+
+// Diagnostics returns the errors found while writing.
+func (cw *CodeWriter) Diagnostics() []Diagnostic {
+	return cw.diags
+}
+
+func (cw *CodeWriter) errorf(line int, format string, args ...any) {
+	cw.diags = append(cw.diags, Diagnostic{File: cw.srcFileName, Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (cw *CodeWriter) InitSP() {
+	// SP = 256
+	cw.writeLine(fmt.Sprintf("@%v", minSP))
+	cw.writeLine("D=A")
+	cw.writeLine("@SP")
+	cw.writeLine("M=D")
+	cw.Call(initFuncName, 0)
+}
+
+func (cw *CodeWriter) Comment(c string) {
+	cw.writeLine("// " + c)
+}
+
+// flowComment writes the comment c of a command which jumps or is jumped to,
+// after spilling the cached top of the stack, so that the code of the command
+// starts at its comment wherever it is reached from. The spill completes the
+// previous command, and is charged to its line.
+func (cw *CodeWriter) flowComment(c string) {
+	start := cw.pc
+	cw.spill()
+	if n := len(cw.sources); n > 1 && cw.pc > start && cw.sources[n-1].Start == start && cw.sources[n-2].End == start {
+		cw.sources[n-2].End = cw.pc
+		cw.sources[n-1].Start = cw.pc
+	}
+	cw.Comment(c)
+}
+
+// --- Arithmetic ---
+
+func (cw *CodeWriter) Add() {
+	cw.Comment("add")
+	if cw.CacheTop {
+		cw.binaryTop("D=D+M")
+		return
+	}
+
+	cw.writeLine("@SP")
+	// decrement SP and set A Register
+	cw.writeLine("AM=M-1")
+	// set y to D Register
+	cw.writeLine("D=M")
+	// set x address to A Register
+	cw.writeLine("A=A-1")
+	// x = x + y
+	cw.writeLine("M=M+D")
+}
+
+func (cw *CodeWriter) Sub() {
+	cw.Comment("sub")
+	if cw.CacheTop {
+		cw.binaryTop("D=M-D")
+		return
+	}
+
+	cw.writeLine("@SP")
+	// decrement SP and set A Register
+	cw.writeLine("AM=M-1")
+	// set y to D Register
+	cw.writeLine("D=M")
+	// set x address to A Register
+	cw.writeLine("A=A-1")
+	// x = x - y
+	cw.writeLine("M=M-D")
+}
+
+func (cw *CodeWriter) Eq() {
+	cw.Comment("eq")
+	if cw.CacheTop {
+		cw.compareTop("JEQ")
+		return
+	}
+	if cw.Compact {
+		cw.compare(0)
+		return
+	}
+
+	cw.writeLine("@SP")
+	// decrement SP and set A Register
+	cw.writeLine("AM=M-1")
+	// set y to D Register
+	cw.writeLine("D=M")
+	// set x address to A Register
+	cw.writeLine("A=A-1")
+	cw.writeLine("D=M-D")
+	// default is -1(true)
+	cw.writeLine("M=-1")
+
+	end := cw.symbols.Next("END_EQ")
+	// if x - y != 0, set 0(false)
+	cw.writeLine("@" + end)
+	cw.writeLine("D;JEQ")
+	cw.writeLine("@SP")
+	cw.writeLine("A=M-1")
+	cw.writeLine("M=0")
+
+	cw.writeLine("(" + end + ")")
+}
+
+func (cw *CodeWriter) Lt() {
+	cw.Comment("lt")
+	if cw.CacheTop {
+		cw.compareTop("JLT")
+		return
+	}
+	if cw.Compact {
+		cw.compare(-1)
+		return
+	}
+
+	cw.writeLine("@SP")
+	// decrement SP and set A Register
+	cw.writeLine("AM=M-1")
+	// set y to D Register
+	cw.writeLine("D=M")
+	// set x address to A Register
+	cw.writeLine("A=A-1")
+	cw.writeLine("D=M-D")
+	// default is -1(true)
+	cw.writeLine("M=-1")
+
+	end := cw.symbols.Next("END_LT")
+	// if NOT x - y < 0, set 0(false)
+	cw.writeLine("@" + end)
+	cw.writeLine("D;JLT")
+	cw.writeLine("@SP")
+	cw.writeLine("A=M-1")
+	cw.writeLine("M=0")
+
+	cw.writeLine("(" + end + ")")
+}
+
+func (cw *CodeWriter) Gt() {
+	cw.Comment("gt")
+	if cw.CacheTop {
+		cw.compareTop("JGT")
+		return
+	}
+	if cw.Compact {
+		cw.compare(1)
+		return
+	}
+
+	cw.writeLine("@SP")
+	// decrement SP and set A Register
+	cw.writeLine("AM=M-1")
+	// set y to D Register
+	cw.writeLine("D=M")
+	// set x address to A Register
+	cw.writeLine("A=A-1")
+	cw.writeLine("D=M-D")
+	// default is -1(true)
+	cw.writeLine("M=-1")
+
+	end := cw.symbols.Next("END_GT")
+	// if NOT x - y > 0, set 0(false)
+	cw.writeLine("@" + end)
+	cw.writeLine("D;JGT")
+	cw.writeLine("@SP")
+	cw.writeLine("A=M-1")
+	cw.writeLine("M=0")
+
+	cw.writeLine("(" + end + ")")
+}
+
+func (cw *CodeWriter) And() {
+	cw.Comment("and")
+	if cw.CacheTop {
+		cw.binaryTop("D=D&M")
+		return
+	}
+	cw.writeLine("@SP")
+	// decrement SP and set A Register
+	cw.writeLine("AM=M-1")
+	// set y to D Register
+	cw.writeLine("D=M")
+	// set x address to A Register
+	cw.writeLine("A=A-1")
+	cw.writeLine("M=M&D")
+}
+
+func (cw *CodeWriter) Or() {
+	cw.Comment("or")
+	if cw.CacheTop {
+		cw.binaryTop("D=D|M")
+		return
+	}
+	cw.writeLine("@SP")
+	// decrement SP and set A Register
+	cw.writeLine("AM=M-1")
+	// set y to D Register
+	cw.writeLine("D=M")
+	// set x address to A Register
+	cw.writeLine("A=A-1")
+	cw.writeLine("M=M|D")
+}
+
+func (cw *CodeWriter) Neg() {
+	cw.Comment("neg")
+	if cw.CacheTop {
+		cw.loadTop()
+		cw.writeLine("D=-D")
+		return
+	}
+	cw.writeLine("@SP")
+	cw.writeLine("A=M-1")
+	cw.writeLine("M=-M")
+}
+
+func (cw *CodeWriter) Not() {
+	cw.Comment("not")
+	if cw.CacheTop {
+		cw.loadTop()
+		cw.writeLine("D=!D")
+		return
+	}
+	cw.writeLine("@SP")
+	cw.writeLine("A=M-1")
+	cw.writeLine("M=!M")
+}
+
+// --- Push / Pop ---
+
+func (cw *CodeWriter) Push(seg Segment, index int) {
+	if index > max15BitInt || index < 0 {
+		cw.errorf(cw.line, "invalid constant value %v has detected, max is %v, min is %v", index, max15BitInt, 0)
+		return
+	}
+	cw.Comment(fmt.Sprintf("push %v %v", seg, index))
+
+	if cw.CacheTop {
+		cw.spill()
+		if cw.load(seg, index) {
+			cw.top = topInD
+		}
+		return
+	}
+	if !cw.load(seg, index) {
+		return
+	}
+	cw.writeLine("@SP")
+	cw.writeLine("AM=M+1") // increment SP
+	cw.writeLine("A=A-1")
+	cw.writeLine("M=D") // push value
+}
+
+// load sets D to the value of a segment entry and reports whether it is valid.
+func (cw *CodeWriter) load(seg Segment, index int) bool {
+	switch seg {
+	case Local:
+		cw.writeLine(fmt.Sprintf("@%v", index))
+		cw.writeLine("D=A")
+		cw.writeLine("@LCL")
+		cw.writeLine("A=M+D") // base + index
+		cw.writeLine("D=M")
+	case Argument:
+		cw.writeLine(fmt.Sprintf("@%v", index))
+		cw.writeLine("D=A")
+		cw.writeLine("@ARG")
+		cw.writeLine("A=M+D") // base + index
+		cw.writeLine("D=M")
+	case This:
+		cw.writeLine(fmt.Sprintf("@%v", index))
+		cw.writeLine("D=A")
+		cw.writeLine("@THIS")
+		cw.writeLine("A=M+D") // base + index
+		cw.writeLine("D=M")
+	case That:
+		cw.writeLine(fmt.Sprintf("@%v", index))
+		cw.writeLine("D=A")
+		cw.writeLine("@THAT")
+		cw.writeLine("A=M+D") // base + index
+		cw.writeLine("D=M")
+	case Pointer:
+		if index == 0 {
+			cw.writeLine("@THIS")
+		} else if index == 1 {
+			cw.writeLine("@THAT")
+		} else {
+			cw.errorf(cw.line, "pointer index must be 0 or 1, but %v", index)
+			return false
+		}
+		cw.writeLine("D=M")
+	case Temp:
+		if index > 7 {
+			cw.errorf(cw.line, "temp index must be 0 ~ 7, but %v", index)
+			return false
+		}
+		cw.writeLine(fmt.Sprintf("@R%v", tempBase+index))
+		cw.writeLine("D=M")
+	case Constant:
+		cw.writeLine(fmt.Sprintf("@%v", index))
+		cw.writeLine("D=A")
+	case Static:
+		cw.writeLine(fmt.Sprintf("@%v.%v", cw.srcFileName, index))
+		cw.writeLine("D=M")
+	default:
+		cw.errorf(cw.line, "unknown memory segment %q has detected", seg)
+		return false
+	}
+	return true
+}
+
+func (cw *CodeWriter) Pop(seg Segment, index int) {
+	if index > max15BitInt || index < 0 {
+		cw.errorf(cw.line, "invalid constant value %v has detected, max is %v, min is %v", index, max15BitInt, 0)
+		return
+	}
+	cw.Comment(fmt.Sprintf("pop %v %v", seg, index))
+
+	if cw.CacheTop {
+		if cw.popTop(seg, index) {
+			return
+		}
+		cw.spill()
+	}
+	switch seg {
+	case Local:
+		cw.writeLine(fmt.Sprintf("@%v", index))
+		cw.writeLine("D=A")
+		cw.writeLine("@LCL")
+		cw.writeLine("D=M+D") // D is base + index
+
+		cw.writeLine("@R13")
+		cw.writeLine("M=D")
+
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M-1") // decrement SP
+		cw.writeLine("D=M")    // value in SP
+		cw.writeLine("@R13")
+		cw.writeLine("A=M")
+		cw.writeLine("M=D")
+	case Argument:
+		cw.writeLine(fmt.Sprintf("@%v", index))
+		cw.writeLine("D=A")
+		cw.writeLine("@ARG")
+		cw.writeLine("D=M+D") // D is base + index
+
+		cw.writeLine("@R13")
+		cw.writeLine("M=D")
+
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M-1") // decrement SP
+		cw.writeLine("D=M")    // value in SP
+		cw.writeLine("@R13")
+		cw.writeLine("A=M")
+		cw.writeLine("M=D")
+	case This:
+		cw.writeLine(fmt.Sprintf("@%v", index))
+		cw.writeLine("D=A")
+		cw.writeLine("@THIS")
+		cw.writeLine("D=M+D") // D is base + index
+
+		cw.writeLine("@R13")
+		cw.writeLine("M=D")
+
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M-1") // decrement SP
+		cw.writeLine("D=M")    // value in SP
+		cw.writeLine("@R13")
+		cw.writeLine("A=M")
+		cw.writeLine("M=D")
+	case That:
+		cw.writeLine(fmt.Sprintf("@%v", index))
+		cw.writeLine("D=A")
+		cw.writeLine("@THAT")
+		cw.writeLine("D=M+D") // D is base + index
+
+		cw.writeLine("@R13")
+		cw.writeLine("M=D")
+
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M-1") // decrement SP
+		cw.writeLine("D=M")    // value in SP
+		cw.writeLine("@R13")
+		cw.writeLine("A=M")
+		cw.writeLine("M=D")
+	case Pointer:
+		if index != 0 && index != 1 {
+			cw.errorf(cw.line, "pointer index must be 0 or 1, but %v", index)
+			return
+		}
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M-1") // decrement SP
+		cw.writeLine("D=M")
+		if index == 0 {
+			cw.writeLine("@THIS")
+		} else if index == 1 {
+			cw.writeLine("@THAT")
+		}
+		cw.writeLine("M=D")
+	case Temp:
+		if index > 7 {
+			cw.errorf(cw.line, "temp index must be 0 ~ 7, but %v", index)
+			return
+		}
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M-1") // decrement SP
+		cw.writeLine("D=M")    // value in SP
+		cw.writeLine(fmt.Sprintf("@R%v", tempBase+index))
+		cw.writeLine("M=D")
+	// case Constant:
+	case Static:
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M-1") // decrement SP
+		cw.writeLine("D=M")    // value in SP
+		cw.writeLine(fmt.Sprintf("@%v.%v", cw.srcFileName, index))
+		cw.writeLine("M=D")
+	default:
+		cw.errorf(cw.line, "unknown memory segment %q has detected", seg)
+		return
+	}
+}
+
+// --- Flow ---
+
+func (cw *CodeWriter) Label(l string) {
+	cw.flowComment(fmt.Sprintf("label %v", l))
+
+	if cw.labels[l] {
+		cw.errorf(cw.line, "label %q is defined twice in function %v", l, cw.function)
+	}
+	cw.labels[l] = true
+	cw.writeLine("(" + cw.scopedLabel(l) + ")")
+}
+
+func (cw *CodeWriter) Goto(l string) {
+	cw.flowComment(fmt.Sprintf("goto %v", l))
+
+	cw.gotos = append(cw.gotos, labelRef{label: l, line: cw.line})
+	cw.writeLine("@" + cw.scopedLabel(l))
+	cw.writeLine("0;JMP")
+}
+
+func (cw *CodeWriter) IfGoto(l string) {
+	cw.Comment(fmt.Sprintf("if-goto %v", l))
+	cw.conditionalGoto(l, false)
+}
+
+// IfNotGoto jumps to l when the popped value is false.
+func (cw *CodeWriter) IfNotGoto(l string) {
+	cw.Comment(fmt.Sprintf("if-not-goto %v", l))
+	cw.conditionalGoto(l, true)
+}
+
+// invertedJumps are the jumps on x-y for the negation of a comparison.
+var invertedJumps = map[string]string{
+	"JEQ": "JNE",
+	"JLT": "JGE",
+	"JGT": "JLE",
+}
+
+func (cw *CodeWriter) conditionalGoto(l string, onFalse bool) {
+	cw.gotos = append(cw.gotos, labelRef{label: l, line: cw.line})
+	jump := "JNE"
+	if onFalse {
+		jump = "JEQ"
+	}
+	switch cw.top {
+	case topCompare:
+		// jump on x-y instead of materializing the result of the comparison
+		jump = cw.jump
+		if onFalse {
+			jump = invertedJumps[jump]
+		}
+	case topInD:
+	default:
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M-1") // decrement SP
+		cw.writeLine("D=M")    // value in SP
+	}
+	cw.writeLine("@" + cw.scopedLabel(l))
+	cw.writeLine("D;" + jump)
+	cw.top = topInMemory
+}
+
+// scopedLabel returns the assembly label of l, a label of the current
+// function, as FunctionName$label. Labels outside any function are scoped by
+// the class of the file instead, as Class$label, so that they collide neither
+// with the labels of the other files nor with the symbols of the runtime.
+func (cw *CodeWriter) scopedLabel(l string) string {
+	if cw.function == "" {
+		return cw.srcFileName + "$" + l
+	}
+	return cw.function + "$" + l
+}
+
+// endFunction checks that every goto of the current function jumps to a
+// label of the same function.
+func (cw *CodeWriter) endFunction() {
+	for _, ref := range cw.gotos {
+		if cw.labels[ref.label] {
+			continue
+		}
+		if cw.function == "" {
+			cw.errorf(ref.line, "label %q is not defined", ref.label)
+		} else {
+			cw.errorf(ref.line, "label %q is not defined in function %v", ref.label, cw.function)
+		}
+	}
+	cw.labels = map[string]bool{}
+	cw.gotos = nil
+}
+
+// --- Function ---
+
+func (cw *CodeWriter) Func(name string, local int) {
+	cw.flowComment(fmt.Sprintf("function %v %v", name, local))
+
+	cw.endFunction()
+	cw.function = name
+	cw.writeLine("(" + name + ")")
+	if local == 0 {
+		return
+	}
+	cw.writeLine("@LCL")
+	cw.writeLine("AD=M")
+	if local == 1 {
+		cw.writeLine("M=0")
+	} else if local > 1 {
+		cw.writeLine("M=0")
+		for i := 0; i < local-1; i++ {
+			cw.writeLine("AD=D+1")
+			cw.writeLine("M=0")
+		}
+	}
+	cw.writeLine("@SP")
+	cw.writeLine("M=D+1")
+}
+
+func (cw *CodeWriter) Call(name string, arg int) {
+	cw.flowComment(fmt.Sprintf("call %v %v", name, arg))
+
+	// push return-address
+	returnLabel := cw.symbols.Next(name + ".return")
+	if cw.Compact {
+		// R13 = f, R14 = n, R15 = return-address
+		cw.writeLine("@" + name)
+		cw.writeLine("D=A")
+		cw.writeLine("@R13")
+		cw.writeLine("M=D")
+		cw.writeLine("@R14")
+		if arg <= 1 {
+			cw.writeLine(fmt.Sprintf("M=%v", arg))
+		} else {
+			cw.writeLine(fmt.Sprintf("@%v", arg))
+			cw.writeLine("D=A")
+			cw.writeLine("@R14")
+			cw.writeLine("M=D")
+		}
+		cw.writeLine("@" + returnLabel)
+		cw.writeLine("D=A")
+		cw.writeLine("@R15")
+		cw.writeLine("M=D")
+		cw.writeLine("@" + callRoutine)
+		cw.writeLine("0;JMP")
+		cw.writeLine("(" + returnLabel + ")")
+		return
+	}
+	cw.writeLine("@" + returnLabel)
+	cw.writeLine("D=A")
+	cw.writeLine("@SP")
+	cw.writeLine("AM=M+1") // increment SP
+	cw.writeLine("A=A-1")
+	cw.writeLine("M=D") // push value
+	// push current LCL
+	cw.writeLine("@LCL")
+	cw.writeLine("D=M")
+	cw.writeLine("@SP")
+	cw.writeLine("AM=M+1") // increment SP
+	cw.writeLine("A=A-1")
+	cw.writeLine("M=D") // push value
+	// push current ARG
+	cw.writeLine("@ARG")
+	cw.writeLine("D=M")
+	cw.writeLine("@SP")
+	cw.writeLine("AM=M+1") // increment SP
+	cw.writeLine("A=A-1")
+	cw.writeLine("M=D") // push value
+	// push current THIS
+	cw.writeLine("@THIS")
+	cw.writeLine("D=M")
+	cw.writeLine("@SP")
+	cw.writeLine("AM=M+1") // increment SP
+	cw.writeLine("A=A-1")
+	cw.writeLine("M=D") // push value
+	// push current THAT
+	cw.writeLine("@THAT")
+	cw.writeLine("D=M")
+	cw.writeLine("@SP")
+	cw.writeLine("AM=M+1") // increment SP
+	cw.writeLine("A=A-1")
+	cw.writeLine("M=D") // push value
+	// ARG = SP-n-5
+	cw.writeLine("@5")
+	cw.writeLine("D=A")
+	cw.writeLine(fmt.Sprintf("@%v", arg))
+	cw.writeLine("D=D+A")
+	cw.writeLine("@SP")
+	cw.writeLine("D=M-D")
+	cw.writeLine("@ARG")
+	cw.writeLine("M=D")
+	// LCL = SP
+	cw.writeLine("@SP")
+	cw.writeLine("D=M")
+	cw.writeLine("@LCL")
+	cw.writeLine("M=D")
+	// goto f
+	cw.writeLine("@" + name)
+	cw.writeLine("0;JMP")
+	// (return-address)
+	cw.writeLine("(" + returnLabel + ")")
+}
+
+// TailCall writes "call name arg; return" as a jump to name reusing the frame
+// of the current function: the arguments and the frame saved by the call of
+// the current function are moved down to ARG, so that name returns where the
+// current function would have returned and the stack does not grow.
+func (cw *CodeWriter) TailCall(name string, arg int) {
+	cw.flowComment(fmt.Sprintf("call %v %v; return (tail call)", name, arg))
+
+	// push return-address, LCL, ARG, THIS and THAT saved at LCL-5..LCL-1
+	for k := 5; k >= 1; k-- {
+		cw.writeLine("@LCL")
+		cw.writeLine("D=M")
+		cw.writeLine(fmt.Sprintf("@%v", k))
+		cw.writeLine("A=D-A")
+		cw.writeLine("D=M")
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M+1") // increment SP
+		cw.writeLine("A=A-1")
+		cw.writeLine("M=D") // push value
+	}
+	// R13 = SP-n-5, the source, R14 = ARG, the destination, R15 = n+5
+	words := arg + 5
+	cw.writeLine(fmt.Sprintf("@%v", words))
+	cw.writeLine("D=A")
+	cw.writeLine("@R15")
+	cw.writeLine("M=D")
+	cw.writeLine("@SP")
+	cw.writeLine("D=M")
+	cw.writeLine("@R15")
+	cw.writeLine("D=D-M")
+	cw.writeLine("@R13")
+	cw.writeLine("M=D")
+	cw.writeLine("@ARG")
+	cw.writeLine("D=M")
+	cw.writeLine("@R14")
+	cw.writeLine("M=D")
+	// SP = LCL = ARG+n+5
+	cw.writeLine(fmt.Sprintf("@%v", words))
+	cw.writeLine("D=D+A")
+	cw.writeLine("@SP")
+	cw.writeLine("M=D")
+	cw.writeLine("@LCL")
+	cw.writeLine("M=D")
+	// The destination is below the source, so copying upward is safe.
+	loop := cw.symbols.Next("TAIL_CALL")
+	cw.writeLine("(" + loop + ")")
+	cw.writeLine("@R13")
+	cw.writeLine("AM=M+1")
+	cw.writeLine("A=A-1")
+	cw.writeLine("D=M")
+	cw.writeLine("@R14")
+	cw.writeLine("AM=M+1")
+	cw.writeLine("A=A-1")
+	cw.writeLine("M=D")
+	cw.writeLine("@R15")
+	cw.writeLine("MD=M-1")
+	cw.writeLine("@" + loop)
+	cw.writeLine("D;JGT")
+	// goto f
+	cw.writeLine("@" + name)
+	cw.writeLine("0;JMP")
+}
+
+func (cw *CodeWriter) Return() {
+	cw.flowComment("return")
+	if cw.Compact {
+		cw.writeLine("@" + returnRoutine)
+		cw.writeLine("0;JMP")
+		return
+	}
+	cw.writeReturn()
+}
+
+// writeReturn writes the code of return, inline or in the shared routine.
+func (cw *CodeWriter) writeReturn() {
+	// FRAME = LCL
+	cw.writeLine("@LCL")
+	cw.writeLine("D=M")
+	cw.writeLine("@R13")
+	cw.writeLine("M=D")
+	// RET(R14) = *(FRAME - 5)
+	cw.writeLine("@5")
+	cw.writeLine("D=A")
+	cw.writeLine("@R13")
+	cw.writeLine("A=M-D")
+	cw.writeLine("D=M")
+	cw.writeLine("@R14")
+	cw.writeLine("M=D")
+	// *ARG = Result
+	cw.writeLine("@SP")
+	cw.writeLine("A=M-1")
+	cw.writeLine("D=M")
+	cw.writeLine("@ARG")
+	cw.writeLine("A=M")
+	cw.writeLine("M=D")
+	// SP = ARG + 1
+	cw.writeLine("@ARG")
+	cw.writeLine("D=M+1")
+	cw.writeLine("@SP")
+	cw.writeLine("M=D")
+	// THAT = *(FRAME-1)
+	cw.writeLine("@R13")
+	cw.writeLine("A=M-1")
+	cw.writeLine("D=M")
+	cw.writeLine("@THAT")
+	cw.writeLine("M=D")
+	// THIS = *(FRAME-2)
+	cw.writeLine("@2")
+	cw.writeLine("D=A")
+	cw.writeLine("@R13")
+	cw.writeLine("A=M-D")
+	cw.writeLine("D=M")
+	cw.writeLine("@THIS")
+	cw.writeLine("M=D")
+	// ARG = *(FRAME-3)
+	cw.writeLine("@3")
+	cw.writeLine("D=A")
+	cw.writeLine("@R13")
+	cw.writeLine("A=M-D")
+	cw.writeLine("D=M")
+	cw.writeLine("@ARG")
+	cw.writeLine("M=D")
+	// LCL = *(FRAME-4)
+	cw.writeLine("@4")
+	cw.writeLine("D=A")
+	cw.writeLine("@R13")
+	cw.writeLine("A=M-D")
+	cw.writeLine("D=M")
+	cw.writeLine("@LCL")
+	cw.writeLine("M=D")
+	// goto RET
+	cw.writeLine("@R14")
+	cw.writeLine("A=M")
+	cw.writeLine("0;JMP")
+}
+
+// --- Stack top caching ---
+
+// segmentPointers are the registers holding the base of a segment.
+var segmentPointers = map[Segment]string{
+	Local:    "LCL",
+	Argument: "ARG",
+	This:     "THIS",
+	That:     "THAT",
+}
+
+// maxPopIncrements is the largest index popTop reaches with A=A+1.
+const maxPopIncrements = 6
+
+// spill writes the top of the stack cached in D to the stack.
+func (cw *CodeWriter) spill() {
+	if cw.top == topInMemory {
+		return
+	}
+	cw.loadTop()
+	cw.writeLine("@SP")
+	cw.writeLine("AM=M+1") // increment SP
+	cw.writeLine("A=A-1")
+	cw.writeLine("M=D") // push value
+	cw.top = topInMemory
+}
+
+// loadTop moves the top of the stack to D, popping it from the stack or
+// materializing a cached comparison.
+func (cw *CodeWriter) loadTop() {
+	switch cw.top {
+	case topInMemory:
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M-1") // decrement SP
+		cw.writeLine("D=M")
+	case topCompare:
+		isTrue, end := cw.symbols.Next("CMP_TRUE"), cw.symbols.Next("CMP_END")
+		cw.writeLine("@" + isTrue)
+		cw.writeLine("D;" + cw.jump)
+		cw.writeLine("D=0")
+		cw.writeLine("@" + end)
+		cw.writeLine("0;JMP")
+		cw.writeLine("(" + isTrue + ")")
+		cw.writeLine("D=-1")
+		cw.writeLine("(" + end + ")")
+	}
+	cw.top = topInD
+}
+
+// binaryTop computes x op y in D with comp, y being the top of the stack in D
+// and x popped from the stack.
+func (cw *CodeWriter) binaryTop(comp string) {
+	cw.loadTop()
+	cw.writeLine("@SP")
+	cw.writeLine("AM=M-1") // decrement SP
+	cw.writeLine(comp)
+}
+
+// compareTop leaves x-y in D, the result being materialized only if the
+// next command is not an if-goto.
+func (cw *CodeWriter) compareTop(jump string) {
+	cw.binaryTop("D=M-D")
+	cw.top = topCompare
+	cw.jump = jump
+}
+
+// popTop pops the top of the stack from D to a segment entry whose address
+// can be computed without D, and reports false for the other entries.
+func (cw *CodeWriter) popTop(seg Segment, index int) bool {
+	var addr []string
+	switch seg {
+	case Local, Argument, This, That:
+		if index > maxPopIncrements {
+			return false
+		}
+		addr = []string{"@" + segmentPointers[seg], "A=M"}
+		for i := 0; i < index; i++ {
+			addr = append(addr, "A=A+1")
+		}
+	case Pointer:
+		if index != 0 && index != 1 {
+			return false
+		}
+		addr = []string{"@THIS"}
+		if index == 1 {
+			addr = []string{"@THAT"}
+		}
+	case Temp:
+		if index > 7 {
+			return false
+		}
+		addr = []string{fmt.Sprintf("@R%v", tempBase+index)}
+	case Static:
+		addr = []string{fmt.Sprintf("@%v.%v", cw.srcFileName, index)}
+	default:
+		return false
+	}
+	cw.loadTop()
+	for _, l := range addr {
+		cw.writeLine(l)
+	}
+	cw.writeLine("M=D")
+	cw.top = topInMemory
+	return true
+}
+
+// --- Runtime ---
+
+// The shared routines of the compact mode. VM names cannot start with '$'.
+const (
+	callRoutine    = "$CALL"
+	returnRoutine  = "$RETURN"
+	compareRoutine = "$COMPARE"
+)
+
+// compare jumps to the shared comparison routine with R13 = -1, 0 or 1, the
+// sign x-y must have for the result to be true, and R15 = return-address.
+func (cw *CodeWriter) compare(sign int) {
+	returnLabel := cw.symbols.Next(compareRoutine)
+	cw.writeLine("@R13")
+	cw.writeLine(fmt.Sprintf("M=%v", sign))
+	cw.writeLine("@" + returnLabel)
+	cw.writeLine("D=A")
+	cw.writeLine("@R15")
+	cw.writeLine("M=D")
+	cw.writeLine("@" + compareRoutine)
+	cw.writeLine("0;JMP")
+	cw.writeLine("(" + returnLabel + ")")
+}
+
+// Runtime writes the shared routines of the compact mode, if Compact is set,
+// and those of the arithmetic commands ops, with a jump over them, so that it
+// can be written before any other code.
+func (cw *CodeWriter) Runtime(ops []string) {
+	cw.Comment("---runtime---")
+	end := "$RUNTIME_END"
+	cw.writeLine("@" + end)
+	cw.writeLine("0;JMP")
+	for _, op := range ops {
+		cw.writeArithRoutine(op)
+	}
+	if !cw.Compact {
+		cw.writeLine("(" + end + ")")
+		return
+	}
+
+	// $CALL: R13 = f, R14 = n, R15 = return-address
+	cw.writeLine("(" + callRoutine + ")")
+	cw.writeLine("@R15")
+	cw.writeLine("D=M")
+	for _, reg := range []string{"", "LCL", "ARG", "THIS", "THAT"} {
+		if reg != "" {
+			cw.writeLine("@" + reg)
+			cw.writeLine("D=M")
+		}
+		cw.writeLine("@SP")
+		cw.writeLine("AM=M+1") // increment SP
+		cw.writeLine("A=A-1")
+		cw.writeLine("M=D") // push value
+	}
+	// ARG = SP-n-5
+	cw.writeLine("@R14")
+	cw.writeLine("D=M")
+	cw.writeLine("@5")
+	cw.writeLine("D=D+A")
+	cw.writeLine("@SP")
+	cw.writeLine("D=M-D")
+	cw.writeLine("@ARG")
+	cw.writeLine("M=D")
+	// LCL = SP
+	cw.writeLine("@SP")
+	cw.writeLine("D=M")
+	cw.writeLine("@LCL")
+	cw.writeLine("M=D")
+	// goto f
+	cw.writeLine("@R13")
+	cw.writeLine("A=M")
+	cw.writeLine("0;JMP")
+
+	cw.writeLine("(" + returnRoutine + ")")
+	cw.writeReturn()
+
+	// $COMPARE: R13 = the sign x-y must have, R15 = return-address
+	cw.writeLine("(" + compareRoutine + ")")
+	cw.writeLine("@SP")
+	cw.writeLine("AM=M-1") // decrement SP
+	cw.writeLine("D=M")
+	cw.writeLine("A=A-1")
+	cw.writeLine("D=M-D")
+	cw.writeLine("@" + compareRoutine + ".GT")
+	cw.writeLine("D;JGT")
+	cw.writeLine("@" + compareRoutine + ".SIGN")
+	cw.writeLine("D;JEQ")
+	cw.writeLine("D=-1")
+	cw.writeLine("@" + compareRoutine + ".SIGN")
+	cw.writeLine("0;JMP")
+	cw.writeLine("(" + compareRoutine + ".GT)")
+	cw.writeLine("D=1")
+	cw.writeLine("(" + compareRoutine + ".SIGN)")
+	// D = 0 if the sign is the expected one
+	cw.writeLine("@R13")
+	cw.writeLine("D=D-M")
+	cw.writeLine("@SP")
+	cw.writeLine("A=M-1")
+	cw.writeLine("M=0")
+	cw.writeLine("@" + compareRoutine + ".END")
+	cw.writeLine("D;JNE")
+	cw.writeLine("@SP")
+	cw.writeLine("A=M-1")
+	cw.writeLine("M=-1")
+	cw.writeLine("(" + compareRoutine + ".END)")
+	cw.writeLine("@R15")
+	cw.writeLine("A=M")
+	cw.writeLine("0;JMP")
+
+	cw.writeLine("(" + end + ")")
+}
+
+func (cw *CodeWriter) writeLine(s string) {
+	if !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "(") {
+		cw.pc++
+	}
+	if _, err := cw.sb.WriteString(s); err != nil {
+		cw.errorf(cw.line, "failed to write to string builder: %v", err)
+	}
+	if _, err := cw.sb.WriteString(cw.newLineChar); err != nil {
+		cw.errorf(cw.line, "failed to write to string builder: %v", err)
+	}
+}
+
+func (cw *CodeWriter) Flush() {
+	cw.spill()
+	cw.endFunction()
+	if _, err := cw.wr.WriteString(cw.sb.String()); err != nil {
+		cw.errorf(cw.line, "failed to flush: %v", err)
+	}
+	if err := cw.wr.Flush(); err != nil {
+		cw.errorf(cw.line, "failed to flush: %v", err)
+	}
+}
+
+// Mul writes x*y, keeping the low 16 bits.
+func (cw *CodeWriter) Mul() {
+	cw.arithCall("mul")
+}
+
+// Div writes x/y rounded toward zero. x/0 is 0, as in the VM interpreter.
+func (cw *CodeWriter) Div() {
+	cw.arithCall("div")
+}
+
+// Shl writes x shifted left by y&15 bits.
+func (cw *CodeWriter) Shl() {
+	cw.arithCall("shl")
+}
+
+// Shr writes x shifted right by y&15 bits, extending its sign.
+func (cw *CodeWriter) Shr() {
+	cw.arithCall("shr")
+}
+
+// arithCall jumps to the routine of op, written by Runtime, with R13 = x,
+// R14 = y and R15 = return-address. The routine leaves the result in place
+// of x on the stack, and uses the free stack above it.
+func (cw *CodeWriter) arithCall(op string) {
+	cw.Comment(op)
+	cw.spill()
+
+	routine := arithRoutine(op)
+	returnLabel := cw.symbols.Next(routine)
+	cw.writeLine("@SP")
+	cw.writeLine("AM=M-1") // decrement SP
+	cw.writeLine("D=M")
+	cw.writeLine("@R14")
+	cw.writeLine("M=D")
+	cw.writeLine("@SP")
+	cw.writeLine("A=M-1")
+	cw.writeLine("D=M")
+	cw.writeLine("@R13")
+	cw.writeLine("M=D")
+	cw.writeLine("@" + returnLabel)
+	cw.writeLine("D=A")
+	cw.writeLine("@R15")
+	cw.writeLine("M=D")
+	cw.writeLine("@" + routine)
+	cw.writeLine("0;JMP")
+	cw.writeLine("(" + returnLabel + ")")
+}
+
+// arithRoutine returns the name of the routine of op: $MUL, $DIV, $SHL or
+// $SHR.
+func arithRoutine(op string) string {
+	return "$" + strings.ToUpper(op)
+}
+
+// writeArithRoutine writes the routine of op called by arithCall.
+func (cw *CodeWriter) writeArithRoutine(op string) {
+	routine := arithRoutine(op)
+	switch op {
+	case "mul":
+		// $MUL: x in R13 doubled at each bit of y, mask in RAM[SP]
+		cw.writeLine("(" + routine + ")")
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("M=0") // result = 0
+		cw.writeLine("@SP")
+		cw.writeLine("A=M")
+		cw.writeLine("M=1") // mask = 1
+		cw.writeLine("(" + routine + ".LOOP)")
+		cw.writeLine("@R14")
+		cw.writeLine("D=M")
+		cw.writeLine("@" + routine + ".END")
+		cw.writeLine("D;JEQ") // no bit left in y
+		cw.writeLine("@SP")
+		cw.writeLine("A=M")
+		cw.writeLine("D=M")
+		cw.writeLine("@R14")
+		cw.writeLine("D=D&M")
+		cw.writeLine("@" + routine + ".NEXT")
+		cw.writeLine("D;JEQ")
+		cw.writeLine("@R14")
+		cw.writeLine("M=M-D") // clear the bit of y
+		cw.writeLine("@R13")
+		cw.writeLine("D=M")
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("M=D+M") // result += x
+		cw.writeLine("(" + routine + ".NEXT)")
+		cw.writeLine("@R13")
+		cw.writeLine("D=M")
+		cw.writeLine("M=D+M") // x += x
+		cw.writeLine("@SP")
+		cw.writeLine("A=M")
+		cw.writeLine("D=M")
+		cw.writeLine("M=D+M") // mask += mask
+		cw.writeLine("@" + routine + ".LOOP")
+		cw.writeLine("0;JMP")
+		cw.writeLine("(" + routine + ".END)")
+		cw.writeLine("@R15")
+		cw.writeLine("A=M")
+		cw.writeLine("0;JMP")
+	case "div":
+		// $DIV: with nx = -|x| and d = -|y|, as -32768 has no positive
+		// counterpart, the multiples d, 2d, 4d, ... not below nx are stacked
+		// from RAM[SP+1], R14 pointing to the last one, then subtracted from
+		// nx from the largest one. RAM[SP] is -1 when the signs differ.
+		cw.writeLine("(" + routine + ")")
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("M=0") // quotient = 0
+		cw.writeLine("@R14")
+		cw.writeLine("D=M")
+		cw.writeLine("@" + routine + ".END")
+		cw.writeLine("D;JEQ") // x/0 is 0
+		cw.writeLine("@R13")
+		cw.writeLine("D=M")
+		cw.writeLine("@" + routine + ".END")
+		cw.writeLine("D;JEQ") // 0/y is 0, and 0 - d would overflow for y = -32768
+		cw.writeLine("@SP")
+		cw.writeLine("A=M")
+		cw.writeLine("M=0")
+		cw.writeLine("@R13")
+		cw.writeLine("D=M")
+		cw.writeLine("@" + routine + ".X")
+		cw.writeLine("D;JLT")
+		cw.writeLine("@R13")
+		cw.writeLine("M=-D") // nx = -x
+		cw.writeLine("@SP")
+		cw.writeLine("A=M")
+		cw.writeLine("M=!M")
+		cw.writeLine("(" + routine + ".X)")
+		cw.writeLine("@R14")
+		cw.writeLine("D=M")
+		cw.writeLine("@" + routine + ".Y")
+		cw.writeLine("D;JLT")
+		cw.writeLine("D=-D") // d = -y
+		cw.writeLine("@SP")
+		cw.writeLine("A=M")
+		cw.writeLine("M=!M")
+		cw.writeLine("(" + routine + ".Y)")
+		cw.writeLine("@SP")
+		cw.writeLine("A=M+1")
+		cw.writeLine("M=D")
+		cw.writeLine("D=A")
+		cw.writeLine("@R14")
+		cw.writeLine("M=D")
+		cw.writeLine("(" + routine + ".DOUBLE)")
+		cw.writeLine("@R14")
+		cw.writeLine("A=M")
+		cw.writeLine("D=M")
+		cw.writeLine("@R13")
+		cw.writeLine("D=M-D") // nx - d
+		cw.writeLine("@" + routine + ".HALVE")
+		cw.writeLine("D;JGT") // d < 0 < nx - d, so 2d < nx
+		cw.writeLine("@R14")
+		cw.writeLine("A=M")
+		cw.writeLine("D=M-D") // 2d - nx, without overflow as nx - d <= 0
+		cw.writeLine("@" + routine + ".HALVE")
+		cw.writeLine("D;JLT")
+		cw.writeLine("@R14")
+		cw.writeLine("A=M")
+		cw.writeLine("D=M")
+		cw.writeLine("D=D+M")
+		cw.writeLine("A=A+1")
+		cw.writeLine("M=D") // stack 2d
+		cw.writeLine("@R14")
+		cw.writeLine("M=M+1")
+		cw.writeLine("@" + routine + ".DOUBLE")
+		cw.writeLine("0;JMP")
+		cw.writeLine("(" + routine + ".HALVE)")
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("D=M")
+		cw.writeLine("M=D+M") // quotient += quotient
+		cw.writeLine("@R14")
+		cw.writeLine("A=M")
+		cw.writeLine("D=M")
+		cw.writeLine("@R13")
+		cw.writeLine("D=M-D") // nx - d
+		cw.writeLine("@" + routine + ".NEXT")
+		cw.writeLine("D;JGT")
+		cw.writeLine("@R13")
+		cw.writeLine("M=D") // nx -= d
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("M=M+1")
+		cw.writeLine("(" + routine + ".NEXT)")
+		cw.writeLine("@R14")
+		cw.writeLine("MD=M-1")
+		cw.writeLine("@SP")
+		cw.writeLine("D=D-M")
+		cw.writeLine("@" + routine + ".HALVE")
+		cw.writeLine("D;JGT") // down to RAM[SP+1]
+		cw.writeLine("@SP")
+		cw.writeLine("A=M")
+		cw.writeLine("D=M")
+		cw.writeLine("@" + routine + ".END")
+		cw.writeLine("D;JEQ")
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("M=-M")
+		cw.writeLine("(" + routine + ".END)")
+		cw.writeLine("@R15")
+		cw.writeLine("A=M")
+		cw.writeLine("0;JMP")
+	case "shl":
+		// $SHL: x doubled y&15 times
+		cw.writeLine("(" + routine + ")")
+		cw.writeLine("@R14")
+		cw.writeLine("D=M")
+		cw.writeLine("@15")
+		cw.writeLine("D=D&A")
+		cw.writeLine("@R14")
+		cw.writeLine("M=D")
+		cw.writeLine("(" + routine + ".LOOP)")
+		cw.writeLine("@R14")
+		cw.writeLine("MD=M-1")
+		cw.writeLine("@" + routine + ".END")
+		cw.writeLine("D;JLT")
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("D=M")
+		cw.writeLine("M=D+M")
+		cw.writeLine("@" + routine + ".LOOP")
+		cw.writeLine("0;JMP")
+		cw.writeLine("(" + routine + ".END)")
+		cw.writeLine("@R15")
+		cw.writeLine("A=M")
+		cw.writeLine("0;JMP")
+	case "shr":
+		// $SHR: the 16-(y&15) high bits of x, from the sign bit, are shifted into
+		// the result, which starts as -1 for a negative x to extend the sign.
+		cw.writeLine("(" + routine + ")")
+		cw.writeLine("@R14")
+		cw.writeLine("D=M")
+		cw.writeLine("@15")
+		cw.writeLine("D=D&A")
+		cw.writeLine("@16")
+		cw.writeLine("D=A-D")
+		cw.writeLine("@R14")
+		cw.writeLine("M=D") // count = 16 - y&15
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("D=M")
+		cw.writeLine("M=0")
+		cw.writeLine("@" + routine + ".LOOP")
+		cw.writeLine("D;JGE")
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("M=-1")
+		cw.writeLine("(" + routine + ".LOOP)")
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("D=M")
+		cw.writeLine("M=D+M") // result += result
+		cw.writeLine("@R13")
+		cw.writeLine("D=M")
+		cw.writeLine("@" + routine + ".NEXT")
+		cw.writeLine("D;JGE")
+		cw.writeLine("@SP")
+		cw.writeLine("A=M-1")
+		cw.writeLine("M=M+1") // shift the sign bit of x in
+		cw.writeLine("(" + routine + ".NEXT)")
+		cw.writeLine("@R13")
+		cw.writeLine("D=M")
+		cw.writeLine("M=D+M") // x += x
+		cw.writeLine("@R14")
+		cw.writeLine("MD=M-1")
+		cw.writeLine("@" + routine + ".LOOP")
+		cw.writeLine("D;JGT")
+		cw.writeLine("@R15")
+		cw.writeLine("A=M")
+		cw.writeLine("0;JMP")
+	}
+}
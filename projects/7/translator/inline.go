@@ -0,0 +1,225 @@
+package translator
+
+import "fmt"
+
+// tempSize is the number of entries of the temp segment.
+const tempSize = 8
+
+// callee is a function that may be inlined.
+type callee struct {
+	file   int
+	locals int
+	body   []Command
+	// args is the number of arguments the body uses.
+	args int
+	// pointers are the pointer entries the body sets, which are restored
+	// after it like a return restores THIS and THAT.
+	pointers   []int
+	usesStatic bool
+}
+
+// inlineFunctions replaces the calls to small functions, of at most maxSize
+// commands, by their bodies, and returns how many calls of each function were
+// replaced. Only the functions calling no other function and using no temp
+// entry are inlined: their arguments and locals are moved to the temp
+// entries the caller does not use, and a call is kept when there are not
+// enough of them. Functions using static variables are only inlined in their
+// own file.
+func inlineFunctions(parsed [][]Command, maxSize int) ([][]Command, map[string]int) {
+	callees := map[string]*callee{}
+	for fi, cmds := range parsed {
+		for i, cmd := range cmds {
+			if cmd.Type != FunctionCommand {
+				continue
+			}
+			end := i + 1
+			for end < len(cmds) && cmds[end].Type != FunctionCommand {
+				end++
+			}
+			if c := inlinable(cmd, cmds[i+1:end], maxSize); c != nil {
+				c.file = fi
+				callees[cmd.Arg1] = c
+			}
+		}
+	}
+
+	inlined := map[string]int{}
+	out := make([][]Command, len(parsed))
+	sites := 0
+	for fi, cmds := range parsed {
+		var free []int
+		for i, cmd := range cmds {
+			if i == 0 || cmd.Type == FunctionCommand {
+				free = freeTemps(cmds[i:])
+			}
+			c := callees[cmd.Arg1]
+			if cmd.Type != CallCommand || c == nil || c.args > cmd.Arg2 ||
+				cmd.Arg2+c.locals+len(c.pointers) > len(free) || c.usesStatic && c.file != fi {
+				out[fi] = append(out[fi], cmd)
+				continue
+			}
+			sites++
+			inlined[cmd.Arg1]++
+			out[fi] = append(out[fi], c.expand(cmd, fmt.Sprintf("%v$%v", cmd.Arg1, sites), free)...)
+		}
+	}
+	return out, inlined
+}
+
+// freeTemps returns the temp entries not used by the commands of cmds up to
+// the next function, which starts them.
+func freeTemps(cmds []Command) []int {
+	used := make([]bool, tempSize)
+	for i, cmd := range cmds {
+		if i > 0 && cmd.Type == FunctionCommand {
+			break
+		}
+		if (cmd.Type == PushCommand || cmd.Type == PopCommand) && Segment(cmd.Arg1) == Temp && cmd.Arg2 < tempSize {
+			used[cmd.Arg2] = true
+		}
+	}
+	var free []int
+	for i, u := range used {
+		if !u {
+			free = append(free, i)
+		}
+	}
+	return free
+}
+
+// inlinable returns the callee of a function with body, or nil if it cannot
+// be inlined: its stack must pass verifyFunction, and it must not fall off
+// its end into the code of the caller.
+func inlinable(function Command, body []Command, maxSize int) *callee {
+	if len(body) == 0 || len(body) > maxSize || len(verifyFunction(function.Arg1, body)) > 0 {
+		return nil
+	}
+	if last := body[len(body)-1].Type; last != ReturnCommand && last != GotoCommand {
+		return nil
+	}
+	c := &callee{locals: function.Arg2, body: body}
+	for _, cmd := range body {
+		switch cmd.Type {
+		case CallCommand:
+			return nil
+		case PushCommand, PopCommand:
+			switch Segment(cmd.Arg1) {
+			case Temp:
+				return nil
+			case Local:
+				if cmd.Arg2 >= c.locals {
+					return nil
+				}
+			case Argument:
+				c.args = max(c.args, cmd.Arg2+1)
+			case Static:
+				c.usesStatic = true
+			case Pointer:
+				if cmd.Type == PopCommand && !contains(c.pointers, cmd.Arg2) {
+					c.pointers = append(c.pointers, cmd.Arg2)
+				}
+			}
+		}
+	}
+	return c
+}
+
+func contains(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// expand returns the commands replacing call. The arguments are in the temp
+// entries free[0] to free[n-1], followed by the locals and the saved
+// pointers. prefix makes the labels of the body unique in the caller.
+func (c *callee) expand(call Command, prefix string, free []int) []Command {
+	n := call.Arg2
+	at := func(t CType, name, arg1 string, arg2 int) Command {
+		return Command{Type: t, Name: name, Arg1: arg1, Arg2: arg2, Line: call.Line}
+	}
+	push := func(seg Segment, i int) Command {
+		if seg == Temp {
+			i = free[i]
+		}
+		return at(PushCommand, "push", string(seg), i)
+	}
+	pop := func(seg Segment, i int) Command {
+		if seg == Temp {
+			i = free[i]
+		}
+		return at(PopCommand, "pop", string(seg), i)
+	}
+
+	var out []Command
+	for i := n - 1; i >= 0; i-- {
+		out = append(out, pop(Temp, i))
+	}
+	for i := 0; i < c.locals; i++ {
+		out = append(out, push(Constant, 0), pop(Temp, n+i))
+	}
+	saved := n + c.locals
+	for i, p := range c.pointers {
+		out = append(out, push(Pointer, p), pop(Temp, saved+i))
+	}
+
+	end := prefix + "$END"
+	for i, cmd := range c.body {
+		switch cmd.Type {
+		case PushCommand, PopCommand:
+			switch Segment(cmd.Arg1) {
+			case Argument:
+				cmd.Arg1, cmd.Arg2 = string(Temp), free[cmd.Arg2]
+			case Local:
+				cmd.Arg1, cmd.Arg2 = string(Temp), free[n+cmd.Arg2]
+			}
+		case LabelCommand, GotoCommand, IfCommand, IfNotCommand:
+			cmd.Arg1 = prefix + "$" + cmd.Arg1
+		case ReturnCommand:
+			if i == len(c.body)-1 {
+				continue
+			}
+			cmd = Command{Type: GotoCommand, Name: "goto", Arg1: end}
+		}
+		cmd.Line = call.Line
+		out = append(out, cmd)
+	}
+	if c.body[len(c.body)-1].Type != ReturnCommand || countReturns(c.body) > 1 {
+		out = append(out, at(LabelCommand, "label", end, 0))
+	}
+	for i, p := range c.pointers {
+		out = append(out, push(Temp, saved+i), pop(Pointer, p))
+	}
+	return out
+}
+
+func countReturns(body []Command) int {
+	n := 0
+	for _, cmd := range body {
+		if cmd.Type == ReturnCommand {
+			n++
+		}
+	}
+	return n
+}
+
+// stackEffect returns how many values cmd pops and pushes.
+func stackEffect(cmd Command) (pop, push int) {
+	switch cmd.Type {
+	case ArithCommand:
+		if cmd.Name == "neg" || cmd.Name == "not" {
+			return 1, 1
+		}
+		return 2, 1
+	case PushCommand:
+		return 0, 1
+	case PopCommand, IfCommand, IfNotCommand, ReturnCommand:
+		return 1, 0
+	case CallCommand:
+		return cmd.Arg2, 1
+	}
+	return 0, 0
+}
@@ -0,0 +1,242 @@
+package translator
+
+// Optimize rewrites the commands of a file into fewer commands doing the same
+// thing, and returns them with the number of commands removed:
+//
+//   - arithmetic on constants is folded: push constant 2; push constant 3; add
+//     becomes push constant 5,
+//   - operations doing nothing are removed: x+0, x-0, x|0, x&-1, x*1, x/1,
+//     shifts by 0, neg; neg, not; not and push x; pop x,
+//   - operations are reduced to cheaper ones: 0-x, x*-1 and x/-1 become a
+//     neg, x*2^k becomes a left shift by k, and a left shift by 1 of a pushed
+//     segment entry becomes its push twice and an add. x/2^k is not reduced:
+//     a right shift rounds the negative x down instead of toward zero,
+//   - not; if-goto becomes a single jump on false,
+//   - commands after a goto or a return are removed up to the next label or
+//     function, except the gotos to undefined labels so that they are still
+//     reported.
+//
+// A rewritten command keeps the line of the first command it replaces.
+func Optimize(cmds []Command) ([]Command, int) {
+	out := cmds
+	for {
+		next := peephole(removeUnreachable(out))
+		if len(next) == len(out) {
+			// The last pass may have rewritten commands without removing any.
+			return next, len(cmds) - len(next)
+		}
+		out = next
+	}
+}
+
+// peephole appends the commands one by one, rewriting the end of the
+// output as long as it matches a pattern.
+func peephole(cmds []Command) []Command {
+	out := make([]Command, 0, len(cmds))
+	for _, cmd := range cmds {
+		out = append(out, cmd)
+		for {
+			n := len(out)
+			rewritten := rewriteTail(out)
+			if rewritten == nil {
+				break
+			}
+			out = append(out[:n-len(rewritten.old)], rewritten.new...)
+		}
+	}
+	return out
+}
+
+type rewrite struct {
+	// old are the commands at the end of the output being replaced by new.
+	old, new []Command
+}
+
+// rewriteTail returns the rewrite of the end of cmds, or nil if none applies.
+func rewriteTail(cmds []Command) *rewrite {
+	n := len(cmds)
+	if n == 0 {
+		return nil
+	}
+	last := cmds[n-1]
+	if n >= 2 {
+		prev := cmds[n-2]
+		switch {
+		case prev.Type == PushCommand && last.Type == PopCommand &&
+			prev.Arg1 == last.Arg1 && prev.Arg2 == last.Arg2 && Segment(prev.Arg1) != Constant:
+			return &rewrite{old: cmds[n-2:]}
+		case isArith(prev, "neg") && isArith(last, "neg"), isArith(prev, "not") && isArith(last, "not"):
+			return &rewrite{old: cmds[n-2:]}
+		case isArith(prev, "not") && last.Type == IfCommand:
+			ifNot := Command{Type: IfNotCommand, Name: "if-not-goto", Arg1: last.Arg1, Line: prev.Line}
+			return &rewrite{old: cmds[n-2:], new: []Command{ifNot}}
+		}
+	}
+	if last.Type != ArithCommand {
+		return nil
+	}
+	// push constant 0; push x; sub is push x; neg
+	if n >= 3 && last.Name == "sub" && cmds[n-2].Type == PushCommand && Segment(cmds[n-2].Arg1) != Constant {
+		if x, nx, ok := constantAt(cmds[:n-2]); ok && x == 0 && nx == 1 {
+			neg := Command{Type: ArithCommand, Name: "neg", Line: last.Line}
+			return &rewrite{old: cmds[n-3:], new: []Command{cmds[n-2], neg}}
+		}
+	}
+
+	y, ny, ok := constantAt(cmds[:n-1])
+	if !ok {
+		return nil
+	}
+	if x, nx, ok := constantAt(cmds[:n-1-ny]); ok && isBinary(last.Name) {
+		// Folding is only worth it if the result takes fewer commands.
+		if folded := constant(apply(last.Name, x, y), cmds[n-1-ny-nx].Line); len(folded) < nx+ny+1 {
+			return &rewrite{old: cmds[n-1-ny-nx:], new: folded}
+		}
+	}
+	switch {
+	case y == 0 && (last.Name == "add" || last.Name == "sub" || last.Name == "or" || last.Name == "shl" || last.Name == "shr"),
+		y == -1 && last.Name == "and",
+		y == 1 && (last.Name == "mul" || last.Name == "div"):
+		return &rewrite{old: cmds[n-1-ny:]}
+	case y == -1 && (last.Name == "mul" || last.Name == "div"):
+		neg := Command{Type: ArithCommand, Name: "neg", Line: last.Line}
+		return &rewrite{old: cmds[n-1-ny:], new: []Command{neg}}
+	case y > 1 && y&(y-1) == 0 && last.Name == "mul":
+		k := 0
+		for ; 1<<k != int(y); k++ {
+		}
+		shl := Command{Type: ArithCommand, Name: "shl", Line: last.Line}
+		return &rewrite{old: cmds[n-1-ny:], new: append(constant(int16(k), cmds[n-1-ny].Line), shl)}
+	case y == 1 && last.Name == "shl" && n >= 3 && cmds[n-3].Type == PushCommand && Segment(cmds[n-3].Arg1) != Constant:
+		// x<<1 is x+x, without the call of the runtime routine.
+		push := cmds[n-3]
+		push.Line = cmds[n-2].Line
+		add := Command{Type: ArithCommand, Name: "add", Line: last.Line}
+		return &rewrite{old: cmds[n-2:], new: []Command{push, add}}
+	}
+	return nil
+}
+
+func isArith(cmd Command, name string) bool {
+	return cmd.Type == ArithCommand && cmd.Name == name
+}
+
+func isBinary(op string) bool {
+	return op != "neg" && op != "not"
+}
+
+// constantAt returns the value of the constant pushed by the last commands
+// of cmds, "push constant c" optionally followed by neg or not, and how many
+// commands push it.
+func constantAt(cmds []Command) (int16, int, bool) {
+	n := len(cmds)
+	isConstant := func(cmd Command) bool {
+		return cmd.Type == PushCommand && Segment(cmd.Arg1) == Constant
+	}
+	switch {
+	case n >= 1 && isConstant(cmds[n-1]):
+		return int16(cmds[n-1].Arg2), 1, true
+	case n >= 2 && isConstant(cmds[n-2]) && isArith(cmds[n-1], "neg"):
+		return -int16(cmds[n-2].Arg2), 2, true
+	case n >= 2 && isConstant(cmds[n-2]) && isArith(cmds[n-1], "not"):
+		return ^int16(cmds[n-2].Arg2), 2, true
+	}
+	return 0, 0, false
+}
+
+// constant returns the commands pushing v.
+func constant(v int16, line int) []Command {
+	push := func(c int16) Command {
+		return Command{Type: PushCommand, Name: "push", Arg1: string(Constant), Arg2: int(c), Line: line}
+	}
+	switch {
+	case v >= 0:
+		return []Command{push(v)}
+	case v == -32768:
+		return []Command{push(32767), {Type: ArithCommand, Name: "not", Line: line}}
+	default:
+		return []Command{push(-v), {Type: ArithCommand, Name: "neg", Line: line}}
+	}
+}
+
+// apply computes a binary arithmetic command with 16 bit arithmetic. Like the
+// code written for them, the comparisons test x-y, which wraps around: 32767
+// is not greater than -1.
+func apply(op string, x, y int16) int16 {
+	switch op {
+	case "add":
+		return x + y
+	case "sub":
+		return x - y
+	case "and":
+		return x & y
+	case "or":
+		return x | y
+	case "eq":
+		return boolValue(x == y)
+	case "gt":
+		return boolValue(x-y > 0)
+	case "lt":
+		return boolValue(x-y < 0)
+	case "mul":
+		return x * y
+	case "div":
+		if y == 0 {
+			return 0
+		}
+		return x / y
+	case "shl":
+		return x << (y & 15)
+	case "shr":
+		return x >> (y & 15)
+	}
+	panic("unknown binary command " + op)
+}
+
+func boolValue(b bool) int16 {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+// removeUnreachable removes the commands following a goto or a return up to
+// the next label or function.
+func removeUnreachable(cmds []Command) []Command {
+	// labels are the labels of each function, "" being outside any function.
+	labels := map[string]map[string]bool{}
+	function := ""
+	for _, cmd := range cmds {
+		switch cmd.Type {
+		case FunctionCommand:
+			function = cmd.Arg1
+		case LabelCommand:
+			if labels[function] == nil {
+				labels[function] = map[string]bool{}
+			}
+			labels[function][cmd.Arg1] = true
+		}
+	}
+
+	out := make([]Command, 0, len(cmds))
+	function = ""
+	reachable := true
+	for _, cmd := range cmds {
+		switch cmd.Type {
+		case FunctionCommand:
+			function = cmd.Arg1
+			reachable = true
+		case LabelCommand:
+			reachable = true
+		}
+		jump := cmd.Type == GotoCommand || cmd.Type == IfCommand || cmd.Type == IfNotCommand
+		if !reachable && !(jump && !labels[function][cmd.Arg1]) {
+			continue
+		}
+		out = append(out, cmd)
+		if cmd.Type == GotoCommand || cmd.Type == ReturnCommand {
+			reachable = false
+		}
+	}
+	return out
+}
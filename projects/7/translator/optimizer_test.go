@@ -0,0 +1,87 @@
+package translator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"fold", "push constant 2\npush constant 3\nadd\n", "push constant 5"},
+		{"fold chain", "push constant 2\npush constant 3\nadd\npush constant 4\nsub\n", "push constant 1"},
+		{"fold negative", "push constant 2\npush constant 3\nsub\n", "push constant 1\nneg"},
+		{"fold comparison", "push constant 2\npush constant 3\nlt\nif-goto L\nlabel L\n",
+			"push constant 1\nneg\nif-goto L\nlabel L"},
+		{"fold gt like Hack", "push constant 32767\npush constant 1\nneg\ngt\n", "push constant 0"},
+		{"fold lt like Hack", "push constant 32767\nnot\npush constant 1\nlt\n", "push constant 0"},
+		{"fold min", "push constant 32767\nneg\npush constant 1\nsub\n", "push constant 32767\nnot"},
+		{"fold mul", "push constant 6\npush constant 7\nmul\n", "push constant 42"},
+		{"fold shr", "push constant 16\nneg\npush constant 2\nshr\n", "push constant 4\nneg"},
+		{"fold div by zero", "push constant 1\npush constant 0\ndiv\n", "push constant 0"},
+		{"mul by one", "push local 0\npush constant 1\nmul\n", "push local 0"},
+		{"div by one", "push local 0\npush constant 1\ndiv\n", "push local 0"},
+		{"shift by zero", "push local 0\npush constant 0\nshr\n", "push local 0"},
+		{"mul by minus one", "push local 0\npush constant 1\nneg\nmul\n", "push local 0\nneg"},
+		{"div by minus one", "push local 0\npush constant 1\nneg\ndiv\n", "push local 0\nneg"},
+		{"mul by power of two", "push local 0\npush constant 8\nmul\n", "push local 0\npush constant 3\nshl"},
+		{"mul by two", "push argument 1\npush constant 2\nmul\n", "push argument 1\npush argument 1\nadd"},
+		{"mul of a result by two", "push local 0\npush local 1\nadd\npush constant 2\nmul\n", "push local 0\npush local 1\nadd\npush constant 1\nshl"},
+		{"no reduction of div by two", "push local 0\npush constant 2\ndiv\n", "push local 0\npush constant 2\ndiv"},
+		{"no longer fold", "push constant 1\nneg\n", "push constant 1\nneg"},
+		{"add zero", "push local 0\npush constant 0\nadd\n", "push local 0"},
+		{"and minus one", "push local 0\npush constant 0\nnot\nand\n", "push local 0"},
+		{"zero minus", "push constant 0\npush local 1\nsub\n", "push local 1\nneg"},
+		{"double neg", "push local 0\nneg\nneg\nnot\nnot\n", "push local 0"},
+		{"push pop", "push static 3\npop static 3\npush local 1\npop local 2\n", "push local 1\npop local 2"},
+		{"not if-goto", "push local 0\nnot\nif-goto L\nlabel L\n", "push local 0\nif-not-goto L\nlabel L"},
+		{"unreachable", "function F.f 0\npush constant 1\nreturn\npush constant 2\nlabel L\ngoto L\npop local 0\nfunction F.g 0\n",
+			"function F.f 0\npush constant 1\nreturn\nlabel L\ngoto L\nfunction F.g 0"},
+		{"unreachable undefined goto", "function F.f 0\nreturn\ngoto NOWHERE\n",
+			"function F.f 0\nreturn\ngoto NOWHERE"},
+		{"label breaks patterns", "push local 0\nlabel L\npop local 0\ngoto L\n", "push local 0\nlabel L\npop local 0\ngoto L"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, diags := Parse(File{Name: "F.vm", Src: []byte(tt.src)})
+			if len(diags) > 0 {
+				t.Fatal(diags)
+			}
+			got, removed := Optimize(cmds)
+			lines := make([]string, len(got))
+			for i, cmd := range got {
+				lines[i] = cmd.String()
+			}
+			if s := strings.Join(lines, "\n"); s != tt.want {
+				t.Errorf("got\n%v\nwant\n%v", s, tt.want)
+			}
+			if removed != len(cmds)-len(got) {
+				t.Errorf("removed %v commands, want %v", removed, len(cmds)-len(got))
+			}
+		})
+	}
+}
+
+func TestOptimizeKeepsLines(t *testing.T) {
+	cmds, _ := Parse(File{Name: "F.vm", Src: []byte("// c\npush constant 1\npush constant 2\nadd\nnot\nif-goto L\nlabel L\n")})
+	got, _ := Optimize(cmds)
+	if got[0].Line != 2 || got[1].Line != 5 {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestTranslateOptimize(t *testing.T) {
+	files := []File{{Name: "F.vm", Src: []byte("push constant 2\npush constant 3\nadd\npush local 0\nnot\nif-goto L\nlabel L\n")}}
+	var report strings.Builder
+	out, diags := (&Translator{Optimize: true, Report: &report}).Translate(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	if !strings.Contains(string(out), "// if-not-goto L\n@SP\nAM=M-1\nD=M\n@F$L\nD;JEQ\n") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if want := "F.vm: the optimizer removed 3 of 7 commands\n"; report.String() != want {
+		t.Errorf("report = %q, want %q", report.String(), want)
+	}
+}
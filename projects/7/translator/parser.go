@@ -0,0 +1,273 @@
+package translator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Command is a single VM command. Arg1 is the segment, label or function
+// name and Arg2 the index or count, depending on Type.
+type Command struct {
+	Type CType
+	// Name is the command as written, lower cased: "add", "push", "if-goto", ...
+	Name string
+	Arg1 string
+	Arg2 int
+	Line int
+}
+
+func (c Command) String() string {
+	switch c.Type {
+	case PushCommand, PopCommand, FunctionCommand, CallCommand:
+		return fmt.Sprintf("%v %v %v", c.Name, c.Arg1, c.Arg2)
+	case LabelCommand, GotoCommand, IfCommand, IfNotCommand:
+		return fmt.Sprintf("%v %v", c.Name, c.Arg1)
+	default:
+		return c.Name
+	}
+}
+
+type CType string
+
+const (
+	ArithCommand    CType = "C_ARITHMETIC"
+	PushCommand     CType = "C_PUSH"
+	PopCommand      CType = "C_POP"
+	LabelCommand    CType = "C_LABEL"
+	GotoCommand     CType = "C_GOTO"
+	IfCommand       CType = "C_IF"
+	FunctionCommand CType = "C_FUNCTION"
+	ReturnCommand   CType = "C_RETURN"
+	CallCommand     CType = "C_CALL"
+	// IfNotCommand jumps when the popped value is false. It is written by
+	// Optimize for "not; if-goto"; .vm files cannot use it.
+	IfNotCommand CType = "C_IF_NOT"
+)
+
+var commands map[string]CType = map[string]CType{
+	"add":      ArithCommand,
+	"sub":      ArithCommand,
+	"neg":      ArithCommand,
+	"eq":       ArithCommand,
+	"gt":       ArithCommand,
+	"lt":       ArithCommand,
+	"and":      ArithCommand,
+	"or":       ArithCommand,
+	"not":      ArithCommand,
+	"push":     PushCommand,
+	"pop":      PopCommand,
+	"label":    LabelCommand,
+	"goto":     GotoCommand,
+	"if-goto":  IfCommand,
+	"function": FunctionCommand,
+	"return":   ReturnCommand,
+	"call":     CallCommand,
+
+	// Extensions computing x*y, x/y, x<<y and x>>y, the shifts by y&15.
+	"mul": ArithCommand,
+	"div": ArithCommand,
+	"shl": ArithCommand,
+	"shr": ArithCommand,
+}
+
+// Parse parses a .vm file. Lines with errors are reported and left out of
+// the returned commands.
+func Parse(f File) ([]Command, []Diagnostic) {
+	var cmds []Command
+	var diags []Diagnostic
+	scanner := bufio.NewScanner(bytes.NewReader(f.Src))
+	for n := 1; scanner.Scan(); n++ {
+		cmd, skip, err := parseCommand(scanner.Text())
+		if err != nil {
+			diags = append(diags, Diagnostic{File: f.Name, Line: n, Msg: err.Error()})
+			continue
+		}
+		if skip {
+			continue
+		}
+		cmd.Line = n
+		cmds = append(cmds, cmd)
+	}
+	if err := scanner.Err(); err != nil {
+		diags = append(diags, Diagnostic{File: f.Name, Msg: err.Error()})
+	}
+	return cmds, diags
+}
+
+func parseCommand(line string) (cmd Command, skip bool, err error) {
+	tokens, skip, err := tokenizeCommand(line)
+	if err != nil || skip {
+		return Command{}, skip, err
+	}
+	if len(tokens) == 0 {
+		return Command{}, false, fmt.Errorf("invalid command line detected %q", line)
+	}
+	cmd.Name = strings.ToLower(tokens[0])
+	cmd.Type, err = CommandType(cmd.Name)
+	if err != nil {
+		return Command{}, false, err
+	}
+	switch cmd.Type {
+	case ArithCommand, ReturnCommand:
+		if len(tokens) != 1 {
+			return Command{}, false, fmt.Errorf("invalid %v command %q has detected", cmd.Name, tokens)
+		}
+	case PushCommand, PopCommand:
+		seg, index, err := validatePushAndPop(tokens)
+		if err != nil {
+			return Command{}, false, err
+		}
+		cmd.Arg1, cmd.Arg2 = string(seg), index
+	case LabelCommand:
+		cmd.Arg1, err = validateLabel(tokens)
+	case GotoCommand, IfCommand:
+		cmd.Arg1, err = validateProgramFlow(tokens)
+	case FunctionCommand, CallCommand:
+		cmd.Arg1, cmd.Arg2, err = validateFuncAndCall(tokens)
+	}
+	if err != nil {
+		return Command{}, false, err
+	}
+	return cmd, false, nil
+}
+
+func CommandType(c string) (CType, error) {
+	command, ok := commands[c]
+	if !ok {
+		return "", fmt.Errorf("unknown command %q has detected", c)
+	}
+	return command, nil
+}
+
+func tokenizeCommand(line string) (tokens []string, skip bool, err error) {
+	// 行頭・行末の空白をトリム
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "//") {
+		return nil, true, nil // 空行またはコメント行はスキップ
+	}
+
+	var tokenBuilder strings.Builder
+	var tokensCollected []string
+	var isFirstToken, isPrevSlash bool
+	isFirstToken = true
+
+	for i, r := range line {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == ':' || r == '.' || r == '_' || (isFirstToken && r == '-') {
+			if isPrevSlash {
+				return nil, false, fmt.Errorf("invalid character %q detected in line: %q", r, line[:i+1])
+			}
+			tokenBuilder.WriteRune(r) // 有効な文字をトークンに追加
+			isPrevSlash = false
+			continue
+		}
+
+		if r == '/' {
+			if isPrevSlash { // "//" コメント部分を検出
+				isPrevSlash = false
+				break
+			}
+			isPrevSlash = true
+			continue
+		}
+
+		if unicode.IsSpace(r) { // トークンの終了を検出
+			if tokenBuilder.Len() > 0 {
+				if isPrevSlash {
+					return nil, false, fmt.Errorf("invalid character %q detected in line: %q", r, line[:i+1])
+				}
+				tokensCollected = append(tokensCollected, tokenBuilder.String())
+				tokenBuilder.Reset()
+				isFirstToken = false
+				isPrevSlash = false
+			}
+			continue
+		}
+
+		// 不正な文字を検出
+		return nil, false, fmt.Errorf("invalid character %q detected in line: %q", r, line[:i+1])
+	}
+
+	if isPrevSlash {
+		return nil, false, fmt.Errorf("invalid character %q detected in line: %q", "/", line)
+	}
+
+	// 最後のトークンを追加
+	if tokenBuilder.Len() > 0 {
+		tokensCollected = append(tokensCollected, tokenBuilder.String())
+	}
+	return tokensCollected, false, nil
+}
+
+func validatePushAndPop(tokens []string) (seg Segment, index int, err error) {
+	if len(tokens) != 3 {
+		return "", 0, fmt.Errorf("invalid push/pop command %q has detected", tokens)
+	}
+	index, err = strconv.Atoi(tokens[2])
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid constant value %q has detected: %v", tokens[2], err)
+	}
+	seg, ok := Segments[tokens[1]]
+	if !ok {
+		return "", 0, fmt.Errorf("invalid segment value %q has detected", tokens[1])
+	}
+	if index > max15BitInt || index < 0 {
+		return "", 0, fmt.Errorf("invalid constant value %v has detected, max is %v, min is %v", index, max15BitInt, 0)
+	}
+	switch {
+	case seg == Pointer && index > 1:
+		return "", 0, fmt.Errorf("pointer index must be 0 or 1, but %v", index)
+	case seg == Temp && index > 7:
+		return "", 0, fmt.Errorf("temp index must be 0 ~ 7, but %v", index)
+	case seg == Constant && strings.ToLower(tokens[0]) == "pop":
+		return "", 0, fmt.Errorf("cannot pop to constant segment")
+	}
+	return seg, index, nil
+}
+
+func validateLabel(tokens []string) (string, error) {
+	if len(tokens) != 2 {
+		return "", fmt.Errorf("invalid label command %q has detected", tokens)
+	}
+	return tokens[1], validateLabelName(tokens[1])
+}
+
+func validateProgramFlow(tokens []string) (label string, err error) {
+	if len(tokens) != 2 {
+		return "", fmt.Errorf("invalid program flow command %q has detected", tokens)
+	}
+	return tokens[1], validateLabelName(tokens[1])
+}
+
+func validateFuncAndCall(tokens []string) (name string, local int, err error) {
+	if len(tokens) != 3 {
+		return "", 0, fmt.Errorf("invalid func/call command %q has detected", tokens)
+	}
+	local, err = strconv.Atoi(tokens[2])
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid value %q has detected: %v", tokens[2], err)
+	}
+	if local < 0 || local > max15BitInt {
+		return "", 0, fmt.Errorf("invalid value %q has detected, max is %v, min is %v", tokens[2], max15BitInt, 0)
+	}
+	return tokens[1], local, validateLabelName(tokens[1])
+}
+
+func validateLabelName(l string) error {
+	if len(l) == 0 {
+		return fmt.Errorf("invalid label %q", l)
+	}
+	first := rune(l[0])
+	if !(unicode.IsLetter(first) || first == '_' || first == '.' || first == ':') {
+		return fmt.Errorf("invalid label %q", l)
+	}
+	for _, r := range l[1:] {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == ':') {
+			return fmt.Errorf("invalid label %q", l)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package translator
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ClassName returns the class of a .vm file: its name without the directory
+// and the .vm extension. It prefixes the static variables of the file.
+func ClassName(name string) string {
+	return strings.TrimSuffix(filepath.Base(name), ".vm")
+}
+
+// checkClasses reports the files whose class cannot prefix their static
+// variables: the classes which are not symbols, and the classes of several
+// files, which would share their static variables.
+func checkClasses(files []File) []Diagnostic {
+	var diags []Diagnostic
+	seen := map[string]string{}
+	for _, f := range files {
+		class := ClassName(f.Name)
+		if err := validateLabelName(class); err != nil {
+			diags = append(diags, Diagnostic{File: f.Name, Msg: fmt.Sprintf("class name %q is not a symbol", class)})
+		}
+		if other, ok := seen[class]; ok {
+			diags = append(diags, Diagnostic{File: f.Name, Msg: fmt.Sprintf("class %v is also the class of %v", class, other)})
+			continue
+		}
+		seen[class] = f.Name
+	}
+	return diags
+}
+
+// predefinedSymbols are the symbols the assembler does not allocate.
+var predefinedSymbols = map[string]bool{
+	"SP": true, "LCL": true, "ARG": true, "THIS": true, "THAT": true,
+	"R0": true, "R1": true, "R2": true, "R3": true, "R4": true, "R5": true, "R6": true, "R7": true,
+	"R8": true, "R9": true, "R10": true, "R11": true, "R12": true, "R13": true, "R14": true, "R15": true,
+	"SCREEN": true, "KBD": true,
+}
+
+// variables returns the symbols of asm which the assembler allocates from
+// RAM[16], in that order: the symbols neither predefined nor defined as a
+// label, in the order they first appear.
+func variables(asm []byte) []string {
+	lines := strings.Split(string(asm), "\n")
+	labels := map[string]bool{}
+	for _, l := range lines {
+		if l = strings.TrimSpace(l); strings.HasPrefix(l, "(") && strings.HasSuffix(l, ")") {
+			labels[l[1:len(l)-1]] = true
+		}
+	}
+	var names []string
+	seen := map[string]bool{}
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if !strings.HasPrefix(l, "@") || len(l) == 1 || l[1] >= '0' && l[1] <= '9' {
+			continue
+		}
+		name := l[1:]
+		if !labels[name] && !predefinedSymbols[name] && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// reportStatics reports the RAM address of every variable of asm, the
+// translation of files. The variables which are not static variables of the
+// files, like the functions called but not defined, are reported as well,
+// as they move the static variables up, and so are the variables which do
+// not fit below the stack.
+func (t *Translator) reportStatics(files []File, parsed [][]Command, asm []byte) {
+	statics := map[string]bool{}
+	for _, name := range staticVariables(files, parsed) {
+		statics[name] = true
+	}
+	vars := variables(asm)
+	for i, name := range vars {
+		t.reportf("%v RAM[%v]\n", name, staticBase+i)
+		if !statics[name] {
+			t.reportf("warning: %v is not a static variable, but a symbol which is not defined\n", name)
+		}
+	}
+	if n := staticBase + len(vars); n > minSP {
+		t.reportf("warning: the %v variables do not fit below the stack at RAM[%v], %v overlap it\n", len(vars), minSP, n-minSP)
+	}
+}
+
+// staticVariables returns the static variables used by the commands of the
+// files, in the order they first appear.
+func staticVariables(files []File, parsed [][]Command) []string {
+	var names []string
+	seen := map[string]bool{}
+	for i, cmds := range parsed {
+		class := ClassName(files[i].Name)
+		for _, cmd := range cmds {
+			if cmd.Type != PushCommand && cmd.Type != PopCommand || Segment(cmd.Arg1) != Static {
+				continue
+			}
+			name := fmt.Sprintf("%v.%v", class, cmd.Arg2)
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
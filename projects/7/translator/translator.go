@@ -0,0 +1,422 @@
+package translator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// File is a .vm source file. Name is used in diagnostics and, through
+// ClassName, as the prefix of the static variables.
+type File struct {
+	Name string
+	Src  []byte
+}
+
+// ReadFiles reads the .vm files at paths.
+func ReadFiles(paths ...string) ([]File, error) {
+	files := make([]File, 0, len(paths))
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{Name: path, Src: src})
+	}
+	return files, nil
+}
+
+// VMFiles returns the .vm and .vmbc files of srcs, in order: a file as is,
+// and the .vm files directly in a directory, sorted by name. The
+// subdirectories are not read, as they hold other programs.
+func VMFiles(srcs ...string) ([]string, error) {
+	var paths []string
+	for _, src := range srcs {
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if ext := filepath.Ext(src); ext != ".vm" && ext != ".vmbc" {
+				return nil, fmt.Errorf("%v is not a .vm or .vmbc file", src)
+			}
+			paths = append(paths, src)
+			continue
+		}
+		entries, err := os.ReadDir(src) // sorted by name
+		if err != nil {
+			return nil, err
+		}
+		n := len(paths)
+		for _, e := range entries {
+			if !e.IsDir() && filepath.Ext(e.Name()) == ".vm" {
+				paths = append(paths, filepath.Join(src, e.Name()))
+			}
+		}
+		if len(paths) == n {
+			return nil, fmt.Errorf("no .vm file in %v", src)
+		}
+	}
+	return paths, nil
+}
+
+// ReadProgram parses the .vm files and decodes the .vmbc files at paths.
+func ReadProgram(paths ...string) ([]ParsedFile, []Diagnostic, error) {
+	var files []ParsedFile
+	var diags []Diagnostic
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if filepath.Ext(path) == ".vmbc" {
+			decoded, err := DecodeBytecode(src)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%v: %v", path, err)
+			}
+			files = append(files, decoded...)
+			continue
+		}
+		cmds, ds := Parse(File{Name: path, Src: src})
+		files = append(files, ParsedFile{Name: path, Commands: cmds})
+		diags = append(diags, ds...)
+	}
+	return files, diags, nil
+}
+
+// Diagnostic is an error found in a .vm file. Line is 0 when the error is
+// not about a single line.
+type Diagnostic struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (d Diagnostic) Error() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%v: %v", d.File, d.Msg)
+	}
+	return fmt.Sprintf("%v:%v: %v", d.File, d.Line, d.Msg)
+}
+
+// BootstrapMode tells whether the code setting SP and calling Sys.init is emitted.
+type BootstrapMode int
+
+const (
+	// BootstrapAuto emits the bootstrap only when Sys.init is defined.
+	BootstrapAuto BootstrapMode = iota
+	BootstrapOn
+	BootstrapOff
+)
+
+// Translator translates a set of .vm files, a translation unit, into a
+// single Hack assembly program.
+type Translator struct {
+	Bootstrap BootstrapMode
+	// Compact shares the code of call, return and the comparisons between
+	// their uses, for programs too large for the ROM otherwise.
+	Compact bool
+	// CacheTop keeps the top of the stack in the D register where it can.
+	CacheTop bool
+	// Optimize rewrites the VM commands with Optimize before translating them.
+	Optimize bool
+	// InlineSize is the size in commands of the largest function whose calls
+	// are replaced by its body. 0 inlines nothing. The body keeps its
+	// arguments and locals in the temp entries its caller does not use.
+	InlineSize int
+	// TailCalls writes the calls followed by a return in a function as
+	// jumps reusing the frame of the function.
+	TailCalls bool
+	// PruneFunctions leaves out the functions Sys.init never calls, directly
+	// or not. The labels of the functions left out are not checked.
+	PruneFunctions bool
+	// StaticMap writes to Report the RAM address the assembler gives to every
+	// variable of the output: the static variables, and the symbols which are
+	// not defined, with a warning.
+	StaticMap bool
+	// Report receives what the optimizations did, if it is not nil.
+	Report io.Writer
+}
+
+// Translate translates files with the default settings.
+func Translate(files []File) ([]byte, []Diagnostic) {
+	return (&Translator{}).Translate(files)
+}
+
+// Translate translates files in order. All the errors of all the files are
+// returned; the output is only meaningful when there is none. The files are
+// parsed and translated concurrently, each into its own buffer, and the
+// output is the same as if they were translated one after the other.
+func (t *Translator) Translate(files []File) ([]byte, []Diagnostic) {
+	out, _, diags := t.TranslateMapped(files)
+	return out, diags
+}
+
+// TranslateMapped is Translate also returning where each instruction of the
+// output comes from, in ROM order.
+func (t *Translator) TranslateMapped(files []File) ([]byte, []SourceRange, []Diagnostic) {
+	parsed := make([][]Command, len(files))
+	fileDiags := make([][]Diagnostic, len(files))
+	forEachFile(len(files), func(i int) {
+		parsed[i], fileDiags[i] = Parse(files[i])
+	})
+	return t.translate(files, parsed, fileDiags)
+}
+
+// TranslateParsed is TranslateMapped for files already parsed, or decoded
+// from bytecode.
+func (t *Translator) TranslateParsed(files []ParsedFile) ([]byte, []SourceRange, []Diagnostic) {
+	names := make([]File, len(files))
+	parsed := make([][]Command, len(files))
+	for i, f := range files {
+		names[i], parsed[i] = File{Name: f.Name}, f.Commands
+	}
+	return t.translate(names, parsed, make([][]Diagnostic, len(files)))
+}
+
+// translate translates the commands parsed from files, fileDiags being the
+// errors found parsing them. Only the names of files are used.
+func (t *Translator) translate(files []File, parsed [][]Command, fileDiags [][]Diagnostic) ([]byte, []SourceRange, []Diagnostic) {
+	depthDiags := make([][]Diagnostic, len(files))
+	removed := make([]int, len(files))
+	forEachFile(len(files), func(i int) {
+		if len(fileDiags[i]) == 0 {
+			// The lines with errors are missing, which would upset the depths.
+			depthDiags[i] = verifyStack(files[i], parsed[i])
+		}
+		if t.Optimize {
+			parsed[i], removed[i] = Optimize(parsed[i])
+		}
+	})
+	diags := checkClasses(files)
+	for i, f := range files {
+		if t.Optimize {
+			t.reportf("%v: the optimizer removed %v of %v commands\n", f.Name, removed[i], len(parsed[i])+removed[i])
+		}
+		diags = append(diags, fileDiags[i]...)
+	}
+	if ds := concat(depthDiags); len(ds) > 0 {
+		// The code for a function with an unbalanced stack would corrupt the
+		// frames, so nothing is written.
+		return nil, nil, append(diags, ds...)
+	}
+
+	if t.InlineSize > 0 {
+		var inlined map[string]int
+		parsed, inlined = inlineFunctions(parsed, t.InlineSize)
+		names := make([]string, 0, len(inlined))
+		for f := range inlined {
+			names = append(names, f)
+		}
+		sort.Strings(names)
+		for _, f := range names {
+			t.reportf("inlined %v calls to %v\n", inlined[f], f)
+		}
+	}
+	if t.PruneFunctions {
+		var removed []string
+		parsed, removed = pruneFunctions(parsed)
+		for _, f := range removed {
+			t.reportf("removed function %v, never called from %v\n", f, initFuncName)
+		}
+		t.reportf("removed %v functions\n", len(removed))
+	}
+
+	var out bytes.Buffer
+	labels := NewLabels()
+	bootstrap := t.Bootstrap == BootstrapOn
+	if t.Bootstrap == BootstrapAuto {
+		bootstrap = definesSysInit(parsed)
+	}
+	if ops := arithRoutines(parsed); t.Compact || len(ops) > 0 {
+		cwriter := NewCodeWriter("", &out, labels)
+		cwriter.Compact = t.Compact
+		cwriter.Runtime(ops)
+		cwriter.Flush()
+		diags = append(diags, cwriter.Diagnostics()...)
+	}
+	if bootstrap {
+		cwriter := NewCodeWriter("", &out, labels)
+		cwriter.Compact = t.Compact
+		cwriter.CacheTop = t.CacheTop
+		cwriter.Comment("---bootstrap---")
+		cwriter.InitSP()
+		cwriter.Flush()
+		diags = append(diags, cwriter.Diagnostics()...)
+	}
+	outs := make([]bytes.Buffer, len(files))
+	fileSources := make([][]SourceRange, len(files))
+	forEachFile(len(files), func(i int) {
+		fileDiags[i], fileSources[i] = t.translateFile(files[i], parsed[i], &outs[i])
+	})
+	var sources []SourceRange
+	pc := countInstructions(out.Bytes())
+	for i := range outs {
+		for _, r := range fileSources[i] {
+			r.Start += pc
+			r.End += pc
+			sources = append(sources, r)
+		}
+		pc += countInstructions(outs[i].Bytes())
+		out.Write(outs[i].Bytes())
+	}
+	diags = append(diags, concat(fileDiags)...)
+	if t.StaticMap && len(diags) == 0 {
+		t.reportStatics(files, parsed, out.Bytes())
+	}
+	return out.Bytes(), sources, diags
+}
+
+// countInstructions counts the instructions of Hack assembly written by a
+// CodeWriter, one per line.
+func countInstructions(asm []byte) int {
+	n := 0
+	for _, l := range bytes.Split(asm, []byte("\n")) {
+		if len(l) > 0 && !bytes.HasPrefix(l, []byte("//")) && !bytes.HasPrefix(l, []byte("(")) {
+			n++
+		}
+	}
+	return n
+}
+
+// forEachFile calls fn for the files 0 to n-1 concurrently, and returns when
+// all the calls have returned.
+func forEachFile(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+func concat(diags [][]Diagnostic) []Diagnostic {
+	var all []Diagnostic
+	for _, ds := range diags {
+		all = append(all, ds...)
+	}
+	return all
+}
+
+func (t *Translator) reportf(format string, args ...any) {
+	if t.Report != nil {
+		fmt.Fprintf(t.Report, format, args...)
+	}
+}
+
+// arithRoutines returns the commands used by parsed which are written as
+// calls to runtime routines: mul, div, shl and shr.
+func arithRoutines(parsed [][]Command) []string {
+	var ops []string
+	for _, op := range []string{"mul", "div", "shl", "shr"} {
+	search:
+		for _, cmds := range parsed {
+			for _, cmd := range cmds {
+				if isArith(cmd, op) {
+					ops = append(ops, op)
+					break search
+				}
+			}
+		}
+	}
+	return ops
+}
+
+func definesSysInit(parsed [][]Command) bool {
+	for _, cmds := range parsed {
+		for _, cmd := range cmds {
+			if cmd.Type == FunctionCommand && cmd.Arg1 == initFuncName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t *Translator) translateFile(f File, cmds []Command, out *bytes.Buffer) ([]Diagnostic, []SourceRange) {
+	class := ClassName(f.Name)
+	cwriter := NewCodeWriter(class, out, NewFileLabels(class))
+	cwriter.Compact = t.Compact
+	cwriter.CacheTop = t.CacheTop
+	cwriter.Comment(fmt.Sprintf("---%s---", f.Name))
+
+	function := ""
+	for i := 0; i < len(cmds); i++ {
+		cmd := cmds[i]
+		cwriter.SetLine(cmd.Line)
+		if t.TailCalls && function != "" && cmd.Type == CallCommand && i+1 < len(cmds) && cmds[i+1].Type == ReturnCommand {
+			cwriter.TailCall(cmd.Arg1, cmd.Arg2)
+			i++
+			continue
+		}
+		switch cmd.Type {
+		case ArithCommand:
+			switch cmd.Name {
+			case "add":
+				cwriter.Add()
+			case "sub":
+				cwriter.Sub()
+			case "neg":
+				cwriter.Neg()
+			case "eq":
+				cwriter.Eq()
+			case "gt":
+				cwriter.Gt()
+			case "lt":
+				cwriter.Lt()
+			case "and":
+				cwriter.And()
+			case "or":
+				cwriter.Or()
+			case "not":
+				cwriter.Not()
+			case "mul":
+				cwriter.Mul()
+			case "div":
+				cwriter.Div()
+			case "shl":
+				cwriter.Shl()
+			case "shr":
+				cwriter.Shr()
+			}
+		case PushCommand:
+			cwriter.Push(Segment(cmd.Arg1), cmd.Arg2)
+		case PopCommand:
+			cwriter.Pop(Segment(cmd.Arg1), cmd.Arg2)
+		case LabelCommand:
+			cwriter.Label(cmd.Arg1)
+		case GotoCommand:
+			cwriter.Goto(cmd.Arg1)
+		case IfCommand:
+			cwriter.IfGoto(cmd.Arg1)
+		case IfNotCommand:
+			cwriter.IfNotGoto(cmd.Arg1)
+		case FunctionCommand:
+			function = cmd.Arg1
+			cwriter.Func(cmd.Arg1, cmd.Arg2)
+		case ReturnCommand:
+			cwriter.Return()
+		case CallCommand:
+			cwriter.Call(cmd.Arg1, cmd.Arg2)
+		}
+	}
+	cwriter.Flush()
+
+	// The code writer only knows the base name of the file.
+	diags := cwriter.Diagnostics()
+	for i := range diags {
+		diags[i].File = f.Name
+	}
+	sources := cwriter.Sources()
+	for i := range sources {
+		sources[i].File = f.Name
+	}
+	return diags, sources
+}
@@ -0,0 +1,451 @@
+package translator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	files, err := ReadFiles("../StackArithmetic/SimpleAdd/SimpleAdd.vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, diags := Translate(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	if strings.Contains(string(out), "Sys.init") {
+		t.Error("bootstrap emitted without Sys.init")
+	}
+	if !strings.Contains(string(out), "// push constant 7\n@7\nD=A\n") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestVMFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Sys.vm", "Main.vm", "notes.txt", "sub/Other.vm"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := VMFiles(dir, filepath.Join(dir, "sub", "Other.vm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "Main.vm"), filepath.Join(dir, "Sys.vm"), filepath.Join(dir, "sub", "Other.vm")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := VMFiles(filepath.Join(dir, "notes.txt")); err == nil {
+		t.Error("no error for a file which is not a .vm file")
+	}
+}
+
+func TestTranslateDiagnostics(t *testing.T) {
+	files := []File{
+		{Name: "A.vm", Src: []byte("push foo 1\nfunction A.f 0\nlabel L\nreturn\n")},
+		{Name: "B.vm", Src: []byte("function B.g 0\npop constant 3\ngoto L\nreturn\nblah\ncall B.g 32768\n")},
+	}
+	_, diags := Translate(files)
+	want := []string{
+		`A.vm:1: invalid segment value "foo" has detected`,
+		`B.vm:2: cannot pop to constant segment`,
+		`B.vm:5: unknown command "blah" has detected`,
+		`B.vm:6: invalid value "32768" has detected, max is 32767, min is 0`,
+		`B.vm:3: label "L" is not defined in function B.g`,
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %v diagnostics, want %v: %v", len(diags), len(want), diags)
+	}
+	for i, w := range want {
+		if diags[i].Error() != w {
+			t.Errorf("diagnostic %v = %q, want %q", i, diags[i].Error(), w)
+		}
+	}
+}
+
+func TestTopLevelLabels(t *testing.T) {
+	files := []File{
+		{Name: "A.vm", Src: []byte("label LOOP\ngoto LOOP\n")},
+		{Name: "B.vm", Src: []byte("label LOOP\ngoto LOOP\n")},
+	}
+	out, diags := Translate(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	for _, want := range []string{"(A$LOOP)\n", "@A$LOOP\n", "(B$LOOP)\n", "@B$LOOP\n"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestTranslateConcurrently(t *testing.T) {
+	paths, _ := filepath.Glob("../../8/FunctionCalls/StaticsTest/*.vm")
+	files, err := ReadFiles(append([]string{"../StackArithmetic/StackTest/StackTest.vm"}, paths...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, diags := Translate(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	outs := make([][]byte, 8)
+	var wg sync.WaitGroup
+	for i := range outs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outs[i], _ = Translate(files)
+		}()
+	}
+	wg.Wait()
+	for i, out := range outs {
+		if !bytes.Equal(out, want) {
+			t.Errorf("translation %v differs from the first one", i)
+		}
+	}
+	if !bytes.Contains(want, []byte("(StackTest$END_EQ1)")) {
+		t.Error("labels do not start from 1")
+	}
+	// The files follow a single bootstrap in order.
+	if bytes.Count(want, []byte("---bootstrap---")) != 1 {
+		t.Error("not a single bootstrap")
+	}
+	last := 0
+	for _, f := range files {
+		i := bytes.Index(want, []byte("---"+f.Name+"---"))
+		if i < last {
+			t.Errorf("%v is out of order", f.Name)
+		}
+		last = i
+	}
+}
+
+// instructions counts the instructions of Hack assembly.
+func instructions(asm []byte) int {
+	n := 0
+	for _, l := range strings.Split(string(asm), "\n") {
+		l = strings.TrimSpace(l)
+		if l != "" && !strings.HasPrefix(l, "//") && !strings.HasPrefix(l, "(") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTranslateCompact(t *testing.T) {
+	paths, err := filepath.Glob("../../9/Average/*.vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := ReadFiles(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, diags := Translate(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	compact, diags := (&Translator{Compact: true}).Translate(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	n, c := instructions(out), instructions(compact)
+	t.Logf("%v instructions, %v in the compact mode", n, c)
+	if c >= n*3/4 {
+		t.Errorf("the compact mode has %v instructions, want less than 3/4 of %v", c, n)
+	}
+	for _, routine := range []string{"($CALL)", "($RETURN)", "($COMPARE)"} {
+		if strings.Count(string(compact), routine) != 1 {
+			t.Errorf("%v is not written once", routine)
+		}
+	}
+}
+
+func TestTranslateCacheTop(t *testing.T) {
+	dirs, err := filepath.Glob("../../8/*/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.vm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := ReadFiles(paths...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, diags := Translate(files)
+		if len(diags) > 0 {
+			t.Fatal(diags)
+		}
+		cached, diags := (&Translator{CacheTop: true}).Translate(files)
+		if len(diags) > 0 {
+			t.Fatal(diags)
+		}
+		n, c := instructions(out), instructions(cached)
+		t.Logf("%v: %v instructions, %v with the top of the stack in D", filepath.Base(dir), n, c)
+		if c >= n {
+			t.Errorf("%v: %v instructions with the top of the stack in D, want less than %v", dir, c, n)
+		}
+	}
+}
+
+func TestPruneFunctions(t *testing.T) {
+	files := []File{
+		{Name: "Sys.vm", Src: []byte("function Sys.init 0\ncall Main.f 0\nlabel END\ngoto END\n")},
+		{Name: "Main.vm", Src: []byte("function Main.f 0\ncall Main.g 0\nreturn\n" +
+			"function Main.h 0\ncall Main.k 0\nreturn\n" +
+			"function Main.g 0\ncall Main.f 0\nreturn\n" +
+			"function Main.k 0\npush constant 1\nreturn\n")},
+	}
+	var report strings.Builder
+	out, diags := (&Translator{PruneFunctions: true, Report: &report}).Translate(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	for _, f := range []string{"Sys.init", "Main.f", "Main.g"} {
+		if !strings.Contains(string(out), "("+f+")") {
+			t.Errorf("%v was removed", f)
+		}
+	}
+	for _, f := range []string{"Main.h", "Main.k"} {
+		if strings.Contains(string(out), f) {
+			t.Errorf("%v was not removed", f)
+		}
+	}
+	want := "removed function Main.h, never called from Sys.init\n" +
+		"removed function Main.k, never called from Sys.init\n" +
+		"removed 2 functions\n"
+	if report.String() != want {
+		t.Errorf("report = %q, want %q", report.String(), want)
+	}
+}
+
+func TestInlineFunctions(t *testing.T) {
+	files := []File{
+		{Name: "Main.vm", Src: []byte("function Main.main 0\npush constant 1\ncall Main.small 1\n" +
+			"call Main.temp 1\ncall Main.caller 1\ncall Main.backward 1\ncall Main.falls 1\nreturn\n" +
+			"function Main.small 0\npush argument 0\nneg\nreturn\n" +
+			"function Main.temp 0\npush argument 0\npop temp 0\npush temp 0\nreturn\n" +
+			"function Main.caller 0\npush argument 0\ncall Main.small 1\nreturn\n" +
+			"function Main.backward 0\ngoto L2\nlabel L1\npush argument 0\nreturn\nlabel L2\ngoto L1\n" +
+			"function Main.falls 0\npush argument 0\nif-goto L\npush constant 1\nreturn\nlabel L\npush constant 2\n")},
+	}
+	var report strings.Builder
+	out, diags := (&Translator{InlineSize: 10, Report: &report}).Translate(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	// Main.small is inlined in Main.main and Main.caller.
+	for _, f := range []string{"Main.small", "Main.backward"} {
+		if strings.Contains(string(out), "call "+f) {
+			t.Errorf("%v is still called:\n%s", f, out)
+		}
+	}
+	for _, f := range []string{"Main.temp", "Main.caller", "Main.falls"} {
+		if !strings.Contains(string(out), "// call "+f+" 1") {
+			t.Errorf("%v was inlined", f)
+		}
+	}
+	if want := "inlined 1 calls to Main.backward\ninlined 2 calls to Main.small\n"; report.String() != want {
+		t.Errorf("report = %q, want %q", report.String(), want)
+	}
+}
+
+func TestInlineKeepsCallerTemps(t *testing.T) {
+	src := "function Main.main 0\npush constant 9\npop temp 0\npush constant 1\ncall Main.small 1\npush temp 0\nadd\nreturn\n" +
+		"function Main.full 0\npush constant 1\n"
+	for i := 0; i < tempSize; i++ {
+		src += fmt.Sprintf("pop temp %v\npush temp %v\n", i, i)
+	}
+	src += "call Main.small 1\nreturn\n" +
+		"function Main.small 0\npush argument 0\nneg\nreturn\n"
+	cmds, diags := Parse(File{Name: "Main.vm", Src: []byte(src)})
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	out, inlined := inlineFunctions([][]Command{cmds}, 10)
+	// The call in Main.full is kept, as all of temp is in use.
+	if inlined["Main.small"] != 1 {
+		t.Fatalf("inlined %v calls, want 1", inlined["Main.small"])
+	}
+	var lines []string
+	for _, cmd := range out[0][:10] {
+		lines = append(lines, cmd.String())
+	}
+	want := "function Main.main 0\npush constant 9\npop temp 0\npush constant 1\n" +
+		"pop temp 1\npush temp 1\nneg\npush temp 0\nadd\nreturn"
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestVerifyStack(t *testing.T) {
+	src := "push constant 1\n" + // outside any function, not checked
+		"function Main.f 0\n" +
+		"push argument 0\n" +
+		"if-goto ONE\n" +
+		"push constant 1\n" +
+		"push constant 2\n" +
+		"label ONE\n" + // line 7, depths 0 and 2
+		"push constant 3\n" +
+		"return\n" +
+		"function Main.g 0\n" +
+		"push constant 1\n" +
+		"push constant 2\n" +
+		"return\n" + // line 13, 2 values
+		"function Main.h 0\n" +
+		"label LOOP\n" + // line 15, depths 0 and 1
+		"push constant 1\n" +
+		"goto LOOP\n" +
+		"function Main.k 0\n" +
+		"pop local 0\n" + // line 19, empty stack
+		"return\n"
+	files := []File{{Name: "Main.vm", Src: []byte(src)}}
+	out, diags := Translate(files)
+	if out != nil {
+		t.Error("the program was translated")
+	}
+	want := []Diagnostic{
+		{File: "Main.vm", Line: 7, Msg: "in Main.f: label ONE is reached with stack depths 0 and 2"},
+		{File: "Main.vm", Line: 13, Msg: "in Main.g: return with 2 values on the stack, want 1"},
+		{File: "Main.vm", Line: 15, Msg: "in Main.h: label LOOP is reached with stack depths 0 and 1"},
+		{File: "Main.vm", Line: 19, Msg: "in Main.k: pop local 0 pops 1 values from a stack of 0"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %v", diags)
+	}
+	for i := range want {
+		if diags[i] != want[i] {
+			t.Errorf("got %v, want %v", diags[i], want[i])
+		}
+	}
+}
+
+func TestStatics(t *testing.T) {
+	files := []File{
+		{Name: "a/Sum.vm", Src: []byte("push static 1\npop static 0\n")},
+		{Name: "a/Item.vm", Src: []byte("push static 0\npush static 1\nadd\npop static 0\n")},
+	}
+	var report strings.Builder
+	out, diags := (&Translator{StaticMap: true, Report: &report}).Translate(files)
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	for _, s := range []string{"@Sum.0\n", "@Sum.1\n", "@Item.0\n", "@Item.1\n"} {
+		if !strings.Contains(string(out), s) {
+			t.Errorf("%q is not used", s)
+		}
+	}
+	if want := "Sum.1 RAM[16]\nSum.0 RAM[17]\nItem.0 RAM[18]\nItem.1 RAM[19]\n"; report.String() != want {
+		t.Errorf("report = %q, want %q", report.String(), want)
+	}
+
+	// The call of an undefined function allocates a variable before them.
+	files = []File{{Name: "Main.vm", Src: []byte("function Main.f 0\ncall Main.missing 0\npop static 0\npush static 0\nreturn\n")}}
+	report.Reset()
+	if _, diags := (&Translator{StaticMap: true, Report: &report}).Translate(files); len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	want := "Main.missing RAM[16]\n" +
+		"warning: Main.missing is not a static variable, but a symbol which is not defined\n" +
+		"Main.0 RAM[17]\n"
+	if report.String() != want {
+		t.Errorf("report = %q, want %q", report.String(), want)
+	}
+
+	var src strings.Builder
+	for i := 0; i < 242; i++ {
+		fmt.Fprintf(&src, "push static %v\n", i)
+	}
+	files = []File{{Name: "Main.vm", Src: []byte(src.String())}}
+	report.Reset()
+	if _, diags := (&Translator{StaticMap: true, Report: &report}).Translate(files); len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	if want := "Main.241 RAM[257]\nwarning: the 242 variables do not fit below the stack at RAM[256], 2 overlap it\n"; !strings.HasSuffix(report.String(), want) {
+		t.Errorf("report ends with %q, want %q", report.String()[max(0, report.Len()-len(want)):], want)
+	}
+
+	files = []File{
+		{Name: "a/Main.vm", Src: []byte("push static 0\n")},
+		{Name: "b/Main.vm", Src: []byte("push static 0\n")},
+		{Name: "b/my-class.vm", Src: []byte("push static 0\n")},
+	}
+	_, diags = Translate(files)
+	wantDiags := []Diagnostic{
+		{File: "b/Main.vm", Msg: "class Main is also the class of a/Main.vm"},
+		{File: "b/my-class.vm", Msg: `class name "my-class" is not a symbol`},
+	}
+	if len(diags) != len(wantDiags) || diags[0] != wantDiags[0] || diags[1] != wantDiags[1] {
+		t.Errorf("got %v, want %v", diags, wantDiags)
+	}
+}
+
+func TestTranslateMapped(t *testing.T) {
+	paths, _ := filepath.Glob("../../8/FunctionCalls/StaticsTest/*.vm")
+	files, err := ReadFiles(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range []Translator{{}, {Compact: true}, {CacheTop: true}, {Compact: true, CacheTop: true}} {
+		out, sources, diags := tr.TranslateMapped(files)
+		if len(diags) > 0 {
+			t.Fatal(diags)
+		}
+		// comments[pc] is the last comment before the instruction pc.
+		var comments []string
+		comment := ""
+		for _, l := range strings.Split(string(out), "\n") {
+			switch {
+			case strings.HasPrefix(l, "// "):
+				comment = l[3:]
+			case l != "" && !strings.HasPrefix(l, "("):
+				comments = append(comments, comment)
+			}
+		}
+		commands := map[string]map[int]Command{}
+		for _, f := range files {
+			cmds, _ := Parse(f)
+			commands[f.Name] = map[int]Command{}
+			for _, cmd := range cmds {
+				commands[f.Name][cmd.Line] = cmd
+			}
+		}
+
+		pc := sources[0].Start // after the runtime and the bootstrap
+		for _, r := range sources {
+			if r.Start != pc || r.End <= r.Start {
+				t.Fatalf("range %+v does not follow %v", r, pc)
+			}
+			pc = r.End
+			cmd := commands[r.File][r.Line]
+			for i := r.Start; i < r.End; i++ {
+				if comments[i] != cmd.String() {
+					t.Errorf("%v:%v is %q, but instruction %v comes from %q", r.File, r.Line, cmd, i, comments[i])
+					break
+				}
+			}
+		}
+		if pc != len(comments) {
+			t.Errorf("the ranges end at %v of %v instructions", pc, len(comments))
+		}
+		if r := sources[len(sources)-1]; r.Function != "Sys.init" {
+			t.Errorf("the last range is in %q", r.Function)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package translator
+
+import (
+	"fmt"
+	"sort"
+)
+
+// verifyStack checks the stack depth along every path through each function
+// of f: it must never be negative, every path reaching a label must agree on
+// it, and it must be exactly 1 at every return. The commands before the first
+// function are not checked, and neither are the jumps to undefined labels,
+// which the code writer reports.
+func verifyStack(f File, cmds []Command) []Diagnostic {
+	var diags []Diagnostic
+	for i, cmd := range cmds {
+		if cmd.Type != FunctionCommand {
+			continue
+		}
+		end := i + 1
+		for end < len(cmds) && cmds[end].Type != FunctionCommand {
+			end++
+		}
+		for _, d := range verifyFunction(cmd.Arg1, cmds[i+1:end]) {
+			diags = append(diags, Diagnostic{File: f.Name, Line: d.line, Msg: d.msg})
+		}
+	}
+	return diags
+}
+
+type depthError struct {
+	line int
+	msg  string
+}
+
+func verifyFunction(name string, body []Command) []depthError {
+	labels := map[string]int{}
+	for i, cmd := range body {
+		if cmd.Type == LabelCommand {
+			labels[cmd.Arg1] = i
+		}
+	}
+
+	// depths[i] is the depth before body[i], -1 until a path reaches it.
+	depths := make([]int, len(body))
+	for i := range depths {
+		depths[i] = -1
+	}
+	reported := map[int]bool{}
+	var errs []depthError
+	errorf := func(i int, format string, args ...any) {
+		if !reported[i] {
+			reported[i] = true
+			errs = append(errs, depthError{line: body[i].Line, msg: fmt.Sprintf("in %v: ", name) + fmt.Sprintf(format, args...)})
+		}
+	}
+	var work []int
+	reach := func(i, depth int) {
+		switch {
+		case depths[i] == -1:
+			depths[i] = depth
+			work = append(work, i)
+		case depths[i] != depth && body[i].Type == LabelCommand:
+			errorf(i, "label %v is reached with stack depths %v and %v", body[i].Arg1, depths[i], depth)
+		}
+	}
+	if len(body) > 0 {
+		reach(0, 0)
+	}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		cmd, depth := body[i], depths[i]
+
+		pop, push := stackEffect(cmd)
+		if depth < pop {
+			errorf(i, "%v pops %v values from a stack of %v", cmd, pop, depth)
+			continue
+		}
+		if cmd.Type == ReturnCommand && depth != 1 {
+			errorf(i, "return with %v values on the stack, want 1", depth)
+		}
+		depth += push - pop
+		switch cmd.Type {
+		case GotoCommand, IfCommand, IfNotCommand:
+			if j, ok := labels[cmd.Arg1]; ok {
+				reach(j, depth)
+			}
+		}
+		switch cmd.Type {
+		case GotoCommand, ReturnCommand:
+		default:
+			// Falling off the end of the function is not checked.
+			if i+1 < len(body) {
+				reach(i+1, depth)
+			}
+		}
+	}
+	sort.SliceStable(errs, func(a, b int) bool { return errs[a].line < errs[b].line })
+	return errs
+}
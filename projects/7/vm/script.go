@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"nand2tetris-5/tst"
+	"nand2tetris-7/translator"
+)
+
+// NewRunner returns a runner of VM emulator test scripts (the *VME.tst files)
+// whose files are relative to dir.
+func NewRunner(dir string) *tst.Runner {
+	return tst.NewRunner(&scriptMachine{dir: dir}, dir)
+}
+
+// RunFile runs the VM emulator test script at path.
+func RunFile(path string) error {
+	return NewRunner(filepath.Dir(path)).RunFile(path)
+}
+
+// scriptMachine lets test scripts load VM files and step through them.
+type scriptMachine struct {
+	dir string
+	m   *Machine
+}
+
+// segmentBases are the variables of the VM emulator naming a segment pointer.
+var segmentBases = map[string]int{
+	"sp":       SP,
+	"local":    LCL,
+	"argument": ARG,
+	"this":     THIS,
+	"that":     THAT,
+}
+
+func (s *scriptMachine) Exec(cmd tst.Command) (bool, error) {
+	switch cmd.Name {
+	case "load":
+		if len(cmd.Args) > 1 {
+			return true, fmt.Errorf("load expects a file or directory name")
+		}
+		path := s.dir
+		if len(cmd.Args) == 1 {
+			path = filepath.Join(s.dir, cmd.Args[0])
+		}
+		paths, err := translator.VMFiles(path)
+		if err != nil {
+			return true, err
+		}
+		files, diags, err := translator.ReadProgram(paths...)
+		if err != nil {
+			return true, err
+		}
+		m := New()
+		if len(diags) == 0 {
+			diags = m.LoadParsed(files)
+		}
+		if len(diags) > 0 {
+			errs := make([]error, len(diags))
+			for i, d := range diags {
+				errs[i] = d
+			}
+			return true, errors.Join(errs...)
+		}
+		s.m = m
+	case "vmstep":
+		if s.m == nil {
+			return true, fmt.Errorf("vmstep before load")
+		}
+		// Like the VM emulator, a step does not stop at labels.
+		for !s.m.Halted {
+			if cmd, _, ok := s.m.Next(); !ok || cmd.Type != translator.LabelCommand {
+				break
+			}
+			if err := s.m.Step(); err != nil {
+				return true, err
+			}
+		}
+		return true, s.m.Step()
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *scriptMachine) Width(name string) int {
+	return 16
+}
+
+// addr returns the RAM address of a variable: RAM[i], sp, local, argument,
+// this, that, or an entry of a segment like local[2] or temp[0].
+func (s *scriptMachine) addr(name string) (int, error) {
+	if s.m == nil {
+		return 0, fmt.Errorf("%v used before load", name)
+	}
+	base, index, indexed, err := tst.SplitVar(name)
+	if err != nil {
+		return 0, err
+	}
+	addr := -1
+	switch {
+	case !indexed:
+		if p, ok := segmentBases[base]; ok {
+			addr = p
+		}
+	case base == "RAM":
+		addr = index
+	case base == "temp":
+		if index < 8 {
+			addr = tempBase + index
+		}
+	default:
+		if p, ok := segmentBases[base]; ok && base != "sp" {
+			addr = int(s.m.RAM[p]) + index
+		}
+	}
+	if addr < 0 || addr >= ramSize {
+		return 0, fmt.Errorf("unknown variable %q", name)
+	}
+	return addr, nil
+}
+
+func (s *scriptMachine) Get(name string) (uint16, error) {
+	addr, err := s.addr(name)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(s.m.RAM[addr]), nil
+}
+
+func (s *scriptMachine) Set(name string, v uint16) error {
+	addr, err := s.addr(name)
+	if err != nil {
+		return err
+	}
+	s.m.RAM[addr] = int16(v)
+	return nil
+}
@@ -0,0 +1,31 @@
+package vm
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestVMEScripts runs the VM emulator test scripts of projects 7 and 8.
+func TestVMEScripts(t *testing.T) {
+	var scripts []string
+	for _, pattern := range []string{"../*/*/*VME.tst", "../../8/*/*/*VME.tst"} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts = append(scripts, files...)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no test scripts found")
+	}
+	for _, path := range scripts {
+		t.Run(strings.TrimLeft(path, "./"), func(t *testing.T) {
+			r := NewRunner(filepath.Dir(path))
+			r.OutDir = t.TempDir()
+			if err := r.RunFile(path); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+// Package vm interprets VM programs directly, over a simulated Hack RAM laid
+// out like the one of the translated programs: SP, LCL, ARG, THIS and THAT
+// in RAM[0..4], temp in RAM[5..12], static variables from RAM[16] and the
+// stack from RAM[256].
+package vm
+
+import (
+	"fmt"
+
+	"nand2tetris-7/translator"
+)
+
+const (
+	SP   = 0
+	LCL  = 1
+	ARG  = 2
+	THIS = 3
+	THAT = 4
+
+	ramSize    = 32768
+	tempBase   = 5
+	staticBase = 16
+	staticMax  = 255
+	stackBase  = 256
+
+	initFuncName = "Sys.init"
+)
+
+// instr is a command resolved for execution.
+type instr struct {
+	translator.Command
+	file     int
+	function string
+	// target is the command a goto or a call jumps to.
+	target int
+	// addr is the RAM address of a static variable.
+	addr int16
+}
+
+// Machine runs a VM program. The return addresses pushed by call are indexes
+// of VM commands.
+type Machine struct {
+	RAM [ramSize]int16
+	// PC is the index of the next command to execute.
+	PC     int
+	Steps  uint64
+	Halted bool
+
+	files     []string
+	code      []instr
+	functions map[string]int
+	// Statics maps "File.i" to the RAM address of the static variable.
+	Statics map[string]int16
+}
+
+func New() *Machine {
+	return &Machine{}
+}
+
+// Load parses and links files, replacing the program loaded before. Static
+// variables are allocated from RAM[16] in the order they first appear, like
+// the assembler does for the translated program. RAM is left untouched.
+func (m *Machine) Load(files []translator.File) []translator.Diagnostic {
+	parsed, diags := translator.ParseFiles(files)
+	return append(diags, m.LoadParsed(parsed)...)
+}
+
+// LoadParsed is Load for files already parsed, or decoded from bytecode with
+// translator.DecodeBytecode.
+func (m *Machine) LoadParsed(files []translator.ParsedFile) []translator.Diagnostic {
+	var diags []translator.Diagnostic
+	m.files = make([]string, len(files))
+	m.code = nil
+	m.functions = map[string]int{}
+	m.Statics = map[string]int16{}
+
+	// labels maps function$label, or Class$label outside any function like
+	// the translator, to its command.
+	labels := map[string]int{}
+	scoped := func(file int, function, label string) string {
+		if function == "" {
+			return translator.ClassName(files[file].Name) + "$" + label
+		}
+		return function + "$" + label
+	}
+	for fi, f := range files {
+		m.files[fi] = f.Name
+		class := translator.ClassName(f.Name)
+		function := ""
+		for _, cmd := range f.Commands {
+			in := instr{Command: cmd, file: fi}
+			errorf := func(format string, args ...any) {
+				diags = append(diags, translator.Diagnostic{File: f.Name, Line: cmd.Line, Msg: fmt.Sprintf(format, args...)})
+			}
+			switch cmd.Type {
+			case translator.FunctionCommand:
+				function = cmd.Arg1
+				if _, ok := m.functions[function]; ok {
+					errorf("function %v is defined twice", function)
+				}
+				m.functions[function] = len(m.code)
+			case translator.LabelCommand:
+				key := scoped(fi, function, cmd.Arg1)
+				if _, ok := labels[key]; ok {
+					errorf("label %q is defined twice", cmd.Arg1)
+				}
+				labels[key] = len(m.code)
+			case translator.PushCommand, translator.PopCommand:
+				if translator.Segment(cmd.Arg1) != translator.Static {
+					break
+				}
+				name := fmt.Sprintf("%v.%v", class, cmd.Arg2)
+				addr, ok := m.Statics[name]
+				if !ok {
+					addr = int16(staticBase + len(m.Statics))
+					if addr > staticMax {
+						errorf("too many static variables")
+					}
+					m.Statics[name] = addr
+				}
+				in.addr = addr
+			}
+			in.function = function
+			m.code = append(m.code, in)
+		}
+	}
+	if len(m.code) > 32767 {
+		diags = append(diags, translator.Diagnostic{File: files[len(files)-1].Name, Msg: "program is too large"})
+	}
+
+	for i := range m.code {
+		in := &m.code[i]
+		var ok bool
+		switch in.Type {
+		case translator.GotoCommand, translator.IfCommand, translator.IfNotCommand:
+			if in.target, ok = labels[scoped(in.file, in.function, in.Arg1)]; !ok {
+				diags = append(diags, translator.Diagnostic{File: m.files[in.file], Line: in.Line, Msg: fmt.Sprintf("label %q is not defined in function %v", in.Arg1, in.function)})
+			}
+		case translator.CallCommand:
+			if in.target, ok = m.functions[in.Arg1]; !ok {
+				diags = append(diags, translator.Diagnostic{File: m.files[in.file], Line: in.Line, Msg: fmt.Sprintf("function %v is not defined", in.Arg1)})
+			}
+		}
+	}
+	m.Reset()
+	return diags
+}
+
+// Reset moves the execution to Sys.init if it is defined, or to the first
+// command otherwise, without calling it: the stack is left as it is.
+func (m *Machine) Reset() {
+	m.PC = 0
+	if pc, ok := m.functions[initFuncName]; ok {
+		m.PC = pc
+	}
+	m.Steps = 0
+	m.Halted = len(m.code) == 0
+}
+
+// Bootstrap does what the bootstrap code of the translator does: sets SP to
+// 256 and calls Sys.init. Returning from Sys.init halts the machine.
+func (m *Machine) Bootstrap() error {
+	pc, ok := m.functions[initFuncName]
+	if !ok {
+		return fmt.Errorf("function %v is not defined", initFuncName)
+	}
+	m.RAM[SP] = stackBase
+	if err := m.call(pc, 0, len(m.code)); err != nil {
+		return err
+	}
+	m.Steps = 0
+	m.Halted = false
+	return nil
+}
+
+// Function returns the name of the function being executed.
+func (m *Machine) Function() string {
+	if m.PC >= len(m.code) {
+		return ""
+	}
+	return m.code[m.PC].function
+}
+
+// Next returns the command to execute next and where it comes from.
+func (m *Machine) Next() (cmd translator.Command, file string, ok bool) {
+	if m.PC >= len(m.code) {
+		return translator.Command{}, "", false
+	}
+	in := m.code[m.PC]
+	return in.Command, m.files[in.file], true
+}
+
+// Run executes up to max commands, stopping when the machine halts.
+func (m *Machine) Run(max uint64) error {
+	for i := uint64(0); i < max && !m.Halted; i++ {
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step executes a single command. Errors are reported with the position of
+// the command in its file.
+func (m *Machine) Step() error {
+	if m.Halted {
+		return nil
+	}
+	if m.PC >= len(m.code) {
+		m.Halted = true
+		return nil
+	}
+	in := &m.code[m.PC]
+	if err := m.exec(in); err != nil {
+		return translator.Diagnostic{File: m.files[in.file], Line: in.Line, Msg: err.Error()}
+	}
+	m.Steps++
+	return nil
+}
+
+func (m *Machine) exec(in *instr) error {
+	next := m.PC + 1
+	switch in.Type {
+	case translator.ArithCommand:
+		if err := m.arith(in.Name); err != nil {
+			return err
+		}
+	case translator.PushCommand:
+		addr, value, err := m.segment(in, false)
+		if err != nil {
+			return err
+		}
+		if addr >= 0 {
+			value = m.RAM[addr]
+		}
+		if err := m.push(value); err != nil {
+			return err
+		}
+	case translator.PopCommand:
+		addr, _, err := m.segment(in, true)
+		if err != nil {
+			return err
+		}
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		m.RAM[addr] = v
+	case translator.LabelCommand:
+	case translator.GotoCommand:
+		// "label L; goto L" is how programs halt.
+		if in.target == m.PC-1 {
+			m.Halted = true
+		}
+		next = in.target
+	case translator.IfCommand:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		if v != 0 {
+			next = in.target
+		}
+	case translator.IfNotCommand:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			next = in.target
+		}
+	case translator.FunctionCommand:
+		for i := 0; i < in.Arg2; i++ {
+			if err := m.push(0); err != nil {
+				return err
+			}
+		}
+	case translator.CallCommand:
+		if err := m.call(in.target, in.Arg2, next); err != nil {
+			return err
+		}
+		next = in.target
+	case translator.ReturnCommand:
+		ret, err := m.ret()
+		if err != nil {
+			return err
+		}
+		next = ret
+	}
+	m.PC = next
+	if m.PC >= len(m.code) {
+		m.Halted = true
+	}
+	return nil
+}
+
+func (m *Machine) arith(op string) error {
+	y, err := m.pop()
+	if err != nil {
+		return err
+	}
+	if op == "neg" || op == "not" {
+		if op == "neg" {
+			return m.push(-y)
+		}
+		return m.push(^y)
+	}
+	x, err := m.pop()
+	if err != nil {
+		return err
+	}
+	var v int16
+	switch op {
+	case "add":
+		v = x + y
+	case "sub":
+		v = x - y
+	case "and":
+		v = x & y
+	case "or":
+		v = x | y
+	case "eq":
+		v = boolValue(x == y)
+	case "gt":
+		v = boolValue(x > y)
+	case "lt":
+		v = boolValue(x < y)
+	case "mul":
+		v = x * y
+	case "div":
+		// Like the translated code, x/0 is 0.
+		if y != 0 {
+			v = x / y
+		}
+	case "shl":
+		v = x << (y & 15)
+	case "shr":
+		v = x >> (y & 15)
+	default:
+		return fmt.Errorf("unknown arithmetic command %q", op)
+	}
+	return m.push(v)
+}
+
+func boolValue(b bool) int16 {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+// segment returns the RAM address of a segment entry, or -1 and the value
+// for the constant segment.
+func (m *Machine) segment(in *instr, pop bool) (int, int16, error) {
+	index := in.Arg2
+	base := 0
+	switch translator.Segment(in.Arg1) {
+	case translator.Constant:
+		if pop {
+			return 0, 0, fmt.Errorf("cannot pop to constant segment")
+		}
+		return -1, int16(index), nil
+	case translator.Local:
+		base = int(m.RAM[LCL])
+	case translator.Argument:
+		base = int(m.RAM[ARG])
+	case translator.This:
+		base = int(m.RAM[THIS])
+	case translator.That:
+		base = int(m.RAM[THAT])
+	case translator.Pointer:
+		return THIS + index, 0, nil
+	case translator.Temp:
+		return tempBase + index, 0, nil
+	case translator.Static:
+		return int(in.addr), 0, nil
+	default:
+		return 0, 0, fmt.Errorf("unknown memory segment %q", in.Arg1)
+	}
+	addr := base + index
+	if addr < 0 || addr >= ramSize {
+		return 0, 0, fmt.Errorf("%v %v is out of RAM at address %v", in.Arg1, index, addr)
+	}
+	return addr, 0, nil
+}
+
+func (m *Machine) push(v int16) error {
+	sp := int(m.RAM[SP])
+	if sp < 0 || sp >= ramSize {
+		return fmt.Errorf("stack overflow: SP is %v", sp)
+	}
+	m.RAM[sp] = v
+	m.RAM[SP]++
+	return nil
+}
+
+func (m *Machine) pop() (int16, error) {
+	sp := int(m.RAM[SP]) - 1
+	if sp < 0 || sp >= ramSize {
+		return 0, fmt.Errorf("stack underflow: SP is %v", sp+1)
+	}
+	m.RAM[SP]--
+	return m.RAM[sp], nil
+}
+
+// call pushes the frame of a call with n arguments returning to ret.
+func (m *Machine) call(target, n, ret int) error {
+	for _, v := range []int16{int16(ret), m.RAM[LCL], m.RAM[ARG], m.RAM[THIS], m.RAM[THAT]} {
+		if err := m.push(v); err != nil {
+			return err
+		}
+	}
+	m.RAM[ARG] = m.RAM[SP] - int16(n) - 5
+	m.RAM[LCL] = m.RAM[SP]
+	m.PC = target
+	return nil
+}
+
+// ret restores the frame of the caller and returns the return address.
+func (m *Machine) ret() (int, error) {
+	frame := int(m.RAM[LCL])
+	if frame < 5 || frame > ramSize {
+		return 0, fmt.Errorf("invalid frame: LCL is %v", frame)
+	}
+	ret := int(m.RAM[frame-5])
+	v, err := m.pop()
+	if err != nil {
+		return 0, err
+	}
+	arg := int(m.RAM[ARG])
+	if arg < 0 || arg >= ramSize {
+		return 0, fmt.Errorf("invalid frame: ARG is %v", arg)
+	}
+	m.RAM[arg] = v
+	m.RAM[SP] = int16(arg + 1)
+	m.RAM[THAT] = m.RAM[frame-1]
+	m.RAM[THIS] = m.RAM[frame-2]
+	m.RAM[ARG] = m.RAM[frame-3]
+	m.RAM[LCL] = m.RAM[frame-4]
+	if ret < 0 || ret > len(m.code) {
+		return 0, fmt.Errorf("invalid return address %v", ret)
+	}
+	return ret, nil
+}
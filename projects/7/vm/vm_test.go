@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"path/filepath"
+	"testing"
+
+	"nand2tetris-7/translator"
+)
+
+func load(t *testing.T, pattern string) *Machine {
+	t.Helper()
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := translator.ReadFiles(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New()
+	if diags := m.Load(files); len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	return m
+}
+
+func checkRAM(t *testing.T, m *Machine, want map[int]int16) {
+	t.Helper()
+	for addr, v := range want {
+		if m.RAM[addr] != v {
+			t.Errorf("RAM[%v] = %v, want %v", addr, m.RAM[addr], v)
+		}
+	}
+}
+
+func TestStackTest(t *testing.T) {
+	m := load(t, "../StackArithmetic/StackTest/StackTest.vm")
+	m.RAM[SP] = 256
+	if err := m.Run(1000); err != nil {
+		t.Fatal(err)
+	}
+	checkRAM(t, m, map[int]int16{
+		0: 266, 256: -1, 257: 0, 258: 0, 259: 0, 260: -1,
+		261: 0, 262: -1, 263: 0, 264: 0, 265: -91,
+	})
+}
+
+func TestBasicTest(t *testing.T) {
+	m := load(t, "../MemoryAccess/BasicTest/BasicTest.vm")
+	m.RAM[SP], m.RAM[LCL], m.RAM[ARG], m.RAM[THIS], m.RAM[THAT] = 256, 300, 400, 3000, 3010
+	if err := m.Run(25); err != nil {
+		t.Fatal(err)
+	}
+	checkRAM(t, m, map[int]int16{
+		256: 472, 300: 10, 401: 21, 402: 22, 3006: 36, 3012: 42, 3015: 45, 11: 510,
+	})
+}
+
+func TestFibonacciElement(t *testing.T) {
+	m := load(t, "../../8/FunctionCalls/FibonacciElement/*.vm")
+	if err := m.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(10000); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Halted {
+		t.Fatal("not halted")
+	}
+	checkRAM(t, m, map[int]int16{0: 262, 261: 3})
+}
+
+func TestStatics(t *testing.T) {
+	m := load(t, "../../8/FunctionCalls/StaticsTest/*.vm")
+	m.RAM[SP] = 261
+	if err := m.Run(36); err != nil {
+		t.Fatal(err)
+	}
+	checkRAM(t, m, map[int]int16{0: 263, 261: -2, 262: 8})
+	if m.Statics["Class1.0"] != 16 || m.Statics["Class2.0"] != 18 {
+		t.Errorf("statics = %v", m.Statics)
+	}
+}
+
+func TestComparisonOverflow(t *testing.T) {
+	m := New()
+	src := "push constant 0\npush constant 32767\nsub\npush constant 1\nsub\npush constant 1\nlt\n"
+	if diags := m.Load([]translator.File{{Name: "T.vm", Src: []byte(src)}}); len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	m.RAM[SP] = 256
+	if err := m.Run(100); err != nil {
+		t.Fatal(err)
+	}
+	// -32768 - 1 overflows in Hack arithmetic, but -32768 < 1 must hold.
+	checkRAM(t, m, map[int]int16{0: 257, 256: -1})
+}
+
+func TestLoadErrors(t *testing.T) {
+	m := New()
+	src := "function A.f 0\ngoto NOWHERE\ncall B.g 0\nreturn\n"
+	diags := m.Load([]translator.File{{Name: "A.vm", Src: []byte(src)}})
+	if len(diags) != 2 {
+		t.Fatalf("got %v", diags)
+	}
+	if diags[0].Line != 2 || diags[1].Line != 3 {
+		t.Errorf("got %v", diags)
+	}
+}
+
+func TestTopLevelLabels(t *testing.T) {
+	// Each goto jumps over the push of its file to the label of its file.
+	files := []translator.File{
+		{Name: "A.vm", Src: []byte("goto LOOP\npush constant 7\nlabel LOOP\npush constant 1\n")},
+		{Name: "B.vm", Src: []byte("goto LOOP\npush constant 8\nlabel LOOP\npush constant 2\n")},
+	}
+	m := New()
+	if diags := m.Load(files); len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	m.RAM[SP] = 256
+	if err := m.Run(100); err != nil {
+		t.Fatal(err)
+	}
+	checkRAM(t, m, map[int]int16{0: 258, 256: 1, 257: 2})
+}
+
+func TestLoadBytecode(t *testing.T) {
+	paths, _ := filepath.Glob("../../8/FunctionCalls/FibonacciElement/*.vm")
+	files, err := translator.ReadFiles(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := translator.ParseFiles(files)
+	b, err := translator.EncodeBytecode(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := translator.DecodeBytecode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New()
+	if diags := m.LoadParsed(decoded); len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	if err := m.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(10000); err != nil {
+		t.Fatal(err)
+	}
+	checkRAM(t, m, map[int]int16{0: 262, 261: 3})
+}
+
+func TestExtensions(t *testing.T) {
+	m := New()
+	src := "push constant 7\npush constant 2\nneg\ndiv\npush constant 3\nshl\npush constant 1\nshr\npush constant 5\nmul\n" +
+		"push constant 0\ndiv\n"
+	if diags := m.Load([]translator.File{{Name: "T.vm", Src: []byte(src)}}); len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	m.RAM[SP] = 256
+	if err := m.Run(10); err != nil {
+		t.Fatal(err)
+	}
+	// 7 / -2 is -3, shifted left by 3 and right by 1, times 5.
+	checkRAM(t, m, map[int]int16{0: 257, 256: -60})
+	if err := m.Run(2); err != nil {
+		t.Fatal(err)
+	}
+	// Like the translated code, x/0 is 0.
+	checkRAM(t, m, map[int]int16{0: 257, 256: 0})
+}